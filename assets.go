@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/russross/blackfriday"
+)
+
+// Tags that pull another file into the page, unlike <a> which only links to one
+var assetTagPattern = regexp.MustCompile(`(?is)<(?:img|script|link|source|video|audio|embed|object|iframe|input|track)\b[^>]*>`)
+var linkRelPattern = regexp.MustCompile(`(?is)\brel\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+var assetAttributePattern = regexp.MustCompile(`(?is)\b(src|href|srcset|data|poster)\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+
+// Stylesheet references, in <style> blocks, style attributes and CSS files
+var cssReferencePattern = regexp.MustCompile(`(?i)url\(\s*("[^"]*"|'[^']*'|[^)]*?)\s*\)|@import\s+("[^"]*"|'[^']*')`)
+
+// Marks the files HTML and Markdown documents and their stylesheets pull in as embedded, so they are not converted on their own
+func markAssets(docs []*document) {
+	byPath := map[string]*document{}
+	for _, doc := range docs {
+		byPath[doc.Path] = doc
+	}
+	pending := []*document{}
+	for _, doc := range docs {
+		if content := documentType(doc); !doc.excluded() && doc.Source != "" && (content == "text/html" || content == "text/markdown") {
+			pending = append(pending, doc)
+		}
+	}
+	scanned := map[*document]bool{}
+	for len(pending) > 0 {
+		doc := pending[0]
+		pending = pending[1:]
+		if scanned[doc] {
+			continue
+		}
+		scanned[doc] = true
+		contents, err := ioutil.ReadFile(doc.Source)
+		if err != nil {
+			errLog.Printf("Could not read %s for assets, err: %v", doc.Path, err)
+			continue
+		}
+		if documentType(doc) == "text/markdown" {
+			contents = blackfriday.MarkdownCommon(contents)
+		}
+		for _, ref := range references(string(contents), strings.HasSuffix(strings.ToLower(doc.Path), ".css")) {
+			p, ok := resolveReference(doc.Path, ref)
+			asset := byPath[p]
+			if !ok || asset == nil || asset == doc || asset.excluded() {
+				continue
+			}
+			if asset.Status != statusEmbedded {
+				infoLog.Printf("%s is used by %s\n", asset.Path, doc.Path)
+			}
+			asset.embed(doc)
+			if strings.HasSuffix(strings.ToLower(p), ".css") || isHTML(asset) {
+				pending = append(pending, asset)
+			}
+		}
+	}
+}
+
+// Fails the assets no converted page used, which would otherwise vanish from the output and the summary
+func orphanAssets(docs []*document) {
+	for _, doc := range docs {
+		if doc.Status != statusEmbedded || rendered(doc, map[*document]bool{}) {
+			continue
+		}
+		users := []string{}
+		for _, user := range doc.usedBy {
+			users = append(users, user.Path)
+		}
+		doc.fail(fmt.Errorf("used by %s, which was not converted", strings.Join(users, ", ")))
+	}
+}
+
+// Whether the document was converted or embedded in a page that was
+func rendered(doc *document, seen map[*document]bool) bool {
+	if seen[doc] {
+		return false
+	}
+	seen[doc] = true
+	if doc.Status == statusConverted {
+		return true
+	}
+	if doc.Status != statusEmbedded {
+		return false
+	}
+	for _, user := range doc.usedBy {
+		if rendered(user, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHTML(doc *document) bool {
+	return documentType(doc) == "text/html"
+}
+
+// The URLs a page or stylesheet pulls in
+func references(contents string, css bool) []string {
+	refs := []string{}
+	if !css {
+		for _, tag := range assetTagPattern.FindAllString(contents, -1) {
+			if strings.HasPrefix(strings.ToLower(tag), "<link") && !assetLink(tag) {
+				continue
+			}
+			for _, attribute := range assetAttributePattern.FindAllStringSubmatch(tag, -1) {
+				value := strings.Trim(attribute[2], `"'`)
+				if strings.ToLower(attribute[1]) != "srcset" {
+					refs = append(refs, value)
+					continue
+				}
+				// Candidates such as "a.png 1x, b.png 2x"
+				for _, candidate := range strings.Split(value, ",") {
+					if fields := strings.Fields(candidate); len(fields) > 0 {
+						refs = append(refs, fields[0])
+					}
+				}
+			}
+		}
+	}
+	for _, match := range cssReferencePattern.FindAllStringSubmatch(contents, -1) {
+		refs = append(refs, strings.Trim(match[1]+match[2], `"'`))
+	}
+	return refs
+}
+
+// Whether a <link> pulls its file into the page, rather than pointing at an alternate version or the next page
+func assetLink(tag string) bool {
+	match := linkRelPattern.FindStringSubmatch(tag)
+	if match == nil {
+		return false
+	}
+	for _, rel := range strings.Fields(strings.ToLower(strings.Trim(match[1], `"'`))) {
+		if rel == "stylesheet" || rel == "icon" || rel == "preload" {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolves a reference from a file in the bundle to the path of another, false for URLs outside the bundle
+func resolveReference(from, ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		ref = ref[:i]
+	}
+	u, err := url.Parse(ref)
+	if err != nil || ref == "" || u.Scheme != "" || u.Host != "" || strings.HasPrefix(ref, "//") {
+		return "", false
+	}
+	if strings.HasPrefix(u.Path, "/") {
+		return bundlePath(u.Path), true
+	}
+	return bundlePath(path.Join(path.Dir(from), u.Path)), true
+}
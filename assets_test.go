@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReferences(t *testing.T) {
+	page := `<html><head>
+<link rel="stylesheet" href="css/site.css?v=2">
+<link rel="alternate" type="application/pdf" href="report.pdf"><link rel=next href="page2.html">
+<link href="favicon.ico" rel="shortcut icon">
+<style>@import 'print.css'; body { background: url(img/bg.png) }</style>
+<script src=app.js></script>
+</head><body>
+<a href="other.html">Not an asset</a>
+<img src="https://example.com/logo.png" srcset="img/a.png 1x, img/b.png 2x">
+<div style="background-image: url('data:image/png;base64,AAAA')"></div>
+</body></html>`
+	expected := []string{"css/site.css?v=2", "favicon.ico", "app.js", "https://example.com/logo.png", "img/a.png", "img/b.png", "print.css", "img/bg.png", "data:image/png;base64,AAAA"}
+	if refs := references(page, false); !reflect.DeepEqual(refs, expected) {
+		t.Errorf("Expected %v, got %v", expected, refs)
+	}
+	expected = []string{"../fonts/a.woff2"}
+	if refs := references(`<img src="x.png"> @font-face { src: url("../fonts/a.woff2") }`, true); !reflect.DeepEqual(refs, expected) {
+		t.Errorf("Expected %v, got %v", expected, refs)
+	}
+}
+
+func TestResolveReference(t *testing.T) {
+	for ref, expected := range map[string]string{
+		"img/a.png":          "site/img/a.png",
+		"../fonts/a%20b.ttf": "fonts/a b.ttf",
+		"/css/site.css?v=2":  "css/site.css",
+		"./app.js#main":      "site/app.js",
+		"../../../etc/hosts": "etc/hosts",
+	} {
+		if p, ok := resolveReference("site/index.html", ref); !ok || p != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s", ref, expected, p)
+		}
+	}
+	for _, ref := range []string{"", "#top", "https://example.com/a.png", "//example.com/a.png", "data:image/png;base64,AAAA", "file:///etc/passwd"} {
+		if p, ok := resolveReference("site/index.html", ref); ok {
+			t.Errorf("Expected %s to be left alone, got %s", ref, p)
+		}
+	}
+}
+
+func TestMarkAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"index.html":   `<html><head><link rel="stylesheet" href="css/site.css"></head><body><img src="logo.png"></body></html>`,
+		"css/site.css": `body { font-family: a; } @font-face { src: url(../fonts/a.woff) }`,
+		"fonts/a.woff": "wOFF",
+		"logo.png":     "\x89PNG\r\n\x1a\n",
+		"appendix.png": "\x89PNG\r\n\x1a\n",
+		"skipped.html": `<html><body><img src="appendix.png"></body></html>`,
+		"report.pdf":   "%PDF-1.4",
+	}
+	docs := []*document{}
+	for p, contents := range files {
+		source := filepath.Join(dir, filepath.FromSlash(p))
+		os.MkdirAll(filepath.Dir(source), 0755)
+		ioutil.WriteFile(source, []byte(contents), 0644)
+		docs = append(docs, &document{Path: p, Source: source})
+	}
+	byPath := map[string]*document{}
+	for _, doc := range docs {
+		byPath[doc.Path] = doc
+	}
+	byPath["skipped.html"].exclude(reasonRule, "skip")
+
+	markAssets(docs)
+	for _, p := range []string{"css/site.css", "fonts/a.woff", "logo.png"} {
+		if byPath[p].Status != statusEmbedded {
+			t.Errorf("Expected %s to be embedded, got %q", p, byPath[p].Status)
+		}
+	}
+	if byPath["fonts/a.woff"].Detail != "used by css/site.css" {
+		t.Errorf("Incorrect detail, got %q", byPath["fonts/a.woff"].Detail)
+	}
+	for _, p := range []string{"index.html", "appendix.png", "report.pdf"} {
+		if byPath[p].Status != "" {
+			t.Errorf("Expected %s to be converted on its own, got %q", p, byPath[p].Status)
+		}
+	}
+}
+
+func TestOrphanAssets(t *testing.T) {
+	page := &document{Path: "index.html", Status: statusFailed}
+	report := &document{Path: "report.html", Status: statusConverted}
+	css := &document{Path: "site.css"}
+	font := &document{Path: "a.woff"}
+	logo := &document{Path: "logo.png"}
+	css.embed(page)
+	font.embed(css)
+	logo.embed(page)
+	logo.embed(report)
+
+	orphanAssets([]*document{page, report, css, font, logo})
+	for _, doc := range []*document{css, font} {
+		if doc.Status != statusFailed {
+			t.Errorf("Expected %s to fail with the page, got %q", doc.Path, doc.Status)
+		}
+	}
+	if css.Detail != "used by index.html, which was not converted" {
+		t.Errorf("Incorrect detail, got %q", css.Detail)
+	}
+	if logo.Status != statusEmbedded || logo.Detail != "used by index.html" {
+		t.Errorf("Expected the logo to stay embedded in the report, got %q %q", logo.Status, logo.Detail)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+var chromiumPath = flag.String("chromium", "google-chrome", "Headless Chromium used by the chromium HTML converter")
+var htmlConverter = flag.String("html-converter", "wkhtmltopdf", "Converter for HTML unless the job names one, wkhtmltopdf or chromium")
+
+// Whether name is one of the converters for HTML
+func knownHTMLConverter(name string) bool {
+	return converterFor("text/html", name).Name() == name
+}
+
+// Prints HTML with headless Chromium, falling back to wkhtmltopdf when Chromium fails
+type chromiumConverter struct{}
+
+func (chromiumConverter) Name() string    { return "chromium" }
+func (chromiumConverter) Types() []string { return []string{"text/html", "text/htm"} }
+func (chromiumConverter) Priority() int   { return 0 }
+
+func (chromiumConverter) Convert(doc *document, output string, sp opentracing.Span) error {
+	chromiumSp := opentracing.StartSpan("Chromium printing", opentracing.ChildOf(sp.Context()))
+	err := printWithChromium(doc, output, documentTimeout(doc, 60*time.Second))
+	chromiumSp.Finish()
+	if err == nil {
+		return nil
+	}
+	errLog.Printf("Chromium could not print %s, falling back to wkhtmltopdf, err: %v", doc.Path, err)
+	fallback := wkhtmltopdfConverter{}
+	doc.Converter = fallback.Name()
+	return fallback.Convert(doc, output, sp)
+}
+
+func printWithChromium(doc *document, output string, timeout time.Duration) error {
+	return withBundle(doc, func(bundle *bundleServer) error {
+		return runChromium(doc, bundle, output, timeout)
+	})
+}
+
+// Drives Chromium over the DevTools protocol on the pipes --remote-debugging-pipe opens, fd 3 for commands and fd 4 for replies
+func runChromium(doc *document, bundle *bundleServer, output string, timeout time.Duration) error {
+	page := bundle.documentURL(doc.Path)
+	scratch, err := newScratch()
+	if err != nil {
+		return err
+	}
+	defer scratch.remove()
+	profile, err := filepath.Abs(string(scratch))
+	if err != nil {
+		return err
+	}
+	commandsIn, commands, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer commands.Close()
+	replies, repliesOut, err := os.Pipe()
+	if err != nil {
+		commandsIn.Close()
+		return err
+	}
+	defer replies.Close()
+
+	var stderr bytes.Buffer
+	args := []string{"--headless", "--disable-gpu", "--no-first-run", "--remote-debugging-pipe", "--user-data-dir=" + profile,
+		// Every request, loopback included, goes through the bundle server's allowlist
+		"--proxy-server=" + bundle.URL, "--proxy-bypass-list=<-loopback>", "--disable-background-networking"}
+	// Chromium will not run as root with its own sandbox, so only goes without it when the worker's user runs it
+	if sandboxCredential == nil {
+		args = append(args, "--no-sandbox")
+	}
+	cmd := exec.Command(*chromiumPath, args...)
+	cmd.ExtraFiles = []*os.File{commandsIn, repliesOut}
+	cmd.Stderr = &stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Chromium reaches the bundle server over the loopback interface of the namespace it is started in
+	cleanup, err := sandbox(cmd, true)
+	if err != nil {
+		commandsIn.Close()
+		repliesOut.Close()
+		return err
+	}
+	defer cleanup()
+	err = cmd.Start()
+	commandsIn.Close()
+	repliesOut.Close()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- printPage(newDevtools(commands, replies), page, output, doc.HTML)
+	}()
+	timedOut := false
+	select {
+	case <-time.After(timeout):
+		timedOut = true
+		err = fmt.Errorf("Chromium did not finish within %v", timeout)
+	case err = <-done:
+	}
+	// Chromium leaves helper processes behind, so the whole group goes
+	pgid, _ := syscall.Getpgid(cmd.Process.Pid)
+	syscall.Kill(-pgid, syscall.SIGKILL)
+	cmd.Wait()
+	if err != nil {
+		return &toolError{err, stderr.String(), timedOut, resourceLimit(cmd.ProcessState)}
+	}
+	return nil
+}
+
+// Opens the page in a new tab, waits for it and its scripts to load and prints it
+func printPage(d *devtools, page, output string, o htmlOptions) error {
+	target := struct {
+		TargetID string `json:"targetId"`
+	}{}
+	if err := d.call("", "Target.createTarget", map[string]interface{}{"url": "about:blank"}, &target); err != nil {
+		return err
+	}
+	attached := struct {
+		SessionID string `json:"sessionId"`
+	}{}
+	if err := d.call("", "Target.attachToTarget", map[string]interface{}{"targetId": target.TargetID, "flatten": true}, &attached); err != nil {
+		return err
+	}
+	session := attached.SessionID
+	if err := d.call(session, "Page.enable", nil, nil); err != nil {
+		return err
+	}
+	if o.JavaScript != nil && !*o.JavaScript {
+		if err := d.call(session, "Emulation.setScriptExecutionDisabled", map[string]interface{}{"value": true}, nil); err != nil {
+			return err
+		}
+	}
+	if o.Media != "" {
+		if err := d.call(session, "Emulation.setEmulatedMedia", map[string]interface{}{"media": o.Media}, nil); err != nil {
+			return err
+		}
+	}
+	navigated := struct {
+		ErrorText string `json:"errorText"`
+	}{}
+	if err := d.call(session, "Page.navigate", map[string]interface{}{"url": page}, &navigated); err != nil {
+		return err
+	}
+	if navigated.ErrorText != "" {
+		return fmt.Errorf("Could not load %s: %s", page, navigated.ErrorText)
+	}
+	if err := d.waitFor(session, "Page.loadEventFired"); err != nil {
+		return err
+	}
+	time.Sleep(time.Duration(o.JavaScriptDelay) * time.Millisecond)
+	printed := struct {
+		Data string `json:"data"`
+	}{}
+	if err := d.call(session, "Page.printToPDF", o.printParams(), &printed); err != nil {
+		return err
+	}
+	pdf, err := base64.StdEncoding.DecodeString(printed.Data)
+	if err != nil {
+		return err
+	}
+	if err = writeFile(output, pdf); err != nil {
+		return err
+	}
+	d.send("", "Browser.close", nil)
+	return nil
+}
+
+// A client for the DevTools protocol, where each message is JSON followed by a NUL byte
+type devtools struct {
+	commands io.Writer
+	replies  *bufio.Reader
+	lastID   int
+	events   map[string]bool // Events seen while waiting for replies, by session and method
+}
+
+type devtoolsMessage struct {
+	ID        int             `json:"id,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Params    interface{}     `json:"params,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func newDevtools(commands io.Writer, replies io.Reader) *devtools {
+	return &devtools{commands: commands, replies: bufio.NewReader(replies), events: map[string]bool{}}
+}
+
+func (d *devtools) send(session, method string, params interface{}) (int, error) {
+	d.lastID++
+	message, err := json.Marshal(devtoolsMessage{ID: d.lastID, SessionID: session, Method: method, Params: params})
+	if err != nil {
+		return 0, err
+	}
+	_, err = d.commands.Write(append(message, 0))
+	return d.lastID, err
+}
+
+func (d *devtools) read() (*devtoolsMessage, error) {
+	line, err := d.replies.ReadBytes(0)
+	if err != nil {
+		return nil, err
+	}
+	message := &devtoolsMessage{}
+	if err = json.Unmarshal(line[:len(line)-1], message); err != nil {
+		return nil, err
+	}
+	if message.ID == 0 && message.Method != "" {
+		d.events[message.SessionID+" "+message.Method] = true
+	}
+	return message, nil
+}
+
+// Sends a command and waits for its reply, decoding the result into result when it is not nil
+func (d *devtools) call(session, method string, params, result interface{}) error {
+	id, err := d.send(session, method, params)
+	if err != nil {
+		return err
+	}
+	for {
+		message, err := d.read()
+		if err != nil {
+			return err
+		}
+		if message.ID != id {
+			continue
+		}
+		if message.Error != nil {
+			return fmt.Errorf("%s failed: %s", method, message.Error.Message)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(message.Result, result)
+	}
+}
+
+func (d *devtools) waitFor(session, event string) error {
+	for !d.events[session+" "+event] {
+		if _, err := d.read(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Answers DevTools commands the way Chromium does, sending the load event before replying to the navigation
+func fakeChromium(t *testing.T, commands io.Reader, replies io.WriteCloser, pdf []byte) {
+	defer replies.Close()
+	r := bufio.NewReader(commands)
+	reply := func(message map[string]interface{}) {
+		b, _ := json.Marshal(message)
+		replies.Write(append(b, 0))
+	}
+	for {
+		line, err := r.ReadBytes(0)
+		if err != nil {
+			return
+		}
+		command := struct {
+			ID        int                    `json:"id"`
+			SessionID string                 `json:"sessionId"`
+			Method    string                 `json:"method"`
+			Params    map[string]interface{} `json:"params"`
+		}{}
+		if err := json.Unmarshal(line[:len(line)-1], &command); err != nil {
+			t.Errorf("Invalid command %q", line)
+			return
+		}
+		switch command.Method {
+		case "Target.createTarget":
+			reply(map[string]interface{}{"id": command.ID, "result": map[string]interface{}{"targetId": "T1"}})
+		case "Target.attachToTarget":
+			if command.Params["targetId"] != "T1" {
+				t.Errorf("Attached to the wrong target, got %v", command.Params)
+			}
+			reply(map[string]interface{}{"method": "Target.attachedToTarget", "params": map[string]interface{}{}})
+			reply(map[string]interface{}{"id": command.ID, "result": map[string]interface{}{"sessionId": "S1"}})
+		case "Page.navigate":
+			reply(map[string]interface{}{"sessionId": "S1", "method": "Page.loadEventFired", "params": map[string]interface{}{}})
+			reply(map[string]interface{}{"id": command.ID, "sessionId": "S1", "result": map[string]interface{}{"frameId": "F1"}})
+		case "Page.printToPDF":
+			if command.SessionID != "S1" {
+				t.Errorf("Printed outside the session, got %q", command.SessionID)
+			}
+			reply(map[string]interface{}{"id": command.ID, "sessionId": "S1", "result": map[string]interface{}{"data": base64.StdEncoding.EncodeToString(pdf)}})
+		case "Browser.close":
+			return
+		default:
+			reply(map[string]interface{}{"id": command.ID, "sessionId": command.SessionID, "result": map[string]interface{}{}})
+		}
+	}
+}
+
+func TestPrintPage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	commandsIn, commands := io.Pipe()
+	replies, repliesOut := io.Pipe()
+	go fakeChromium(t, commandsIn, repliesOut, []byte("%PDF-1.7"))
+
+	output := filepath.Join(dir, "page.pdf")
+	if err := printPage(newDevtools(commands, replies), "file:///tmp/page.html", output, htmlOptions{}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if contents, _ := ioutil.ReadFile(output); string(contents) != "%PDF-1.7" {
+		t.Errorf("Expected the printed PDF, got %q", contents)
+	}
+}
+
+func TestDevtoolsError(t *testing.T) {
+	commandsIn, commands := io.Pipe()
+	replies, repliesOut := io.Pipe()
+	go func() {
+		r := bufio.NewReader(commandsIn)
+		r.ReadBytes(0)
+		fmt.Fprint(repliesOut, `{"id": 1, "error": {"code": -32000, "message": "Cannot navigate"}}`+"\x00")
+	}()
+	err := newDevtools(commands, replies).call("S1", "Page.navigate", map[string]interface{}{"url": "file:///missing"}, nil)
+	if err == nil || err.Error() != "Page.navigate failed: Cannot navigate" {
+		t.Errorf("Expected the protocol error, got %v", err)
+	}
+}
+
+func TestPreferredConverter(t *testing.T) {
+	if c := converterFor("text/html", ""); c.Name() != "wkhtmltopdf" {
+		t.Errorf("Expected wkhtmltopdf by default, got %v", c.Name())
+	}
+	if c := converterFor("text/html", "chromium"); c.Name() != "chromium" {
+		t.Errorf("Expected chromium when preferred, got %v", c.Name())
+	}
+	if c := converterFor("application/pdf", "chromium"); c.Name() != "passthrough" {
+		t.Errorf("Expected the preference to only apply to HTML, got %v", c.Name())
+	}
+	if _, err := parseJob(`{"key": "bundle", "html_converter": "chromium"}`); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if _, err := parseJob(`{"key": "bundle", "html_converter": "prince"}`); err == nil {
+		t.Error("Expected an error for an unknown HTML converter")
+	}
+	for name, known := range map[string]bool{"wkhtmltopdf": true, "chromium": true, "chrome": false, "passthrough": false} {
+		if knownHTMLConverter(name) != known {
+			t.Errorf("Expected %s known as an HTML converter to be %v", name, known)
+		}
+	}
+}
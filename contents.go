@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Entries link to this prefix while the contents are measured, the final render has no links of its own
+const contentsLinkPrefix = "https://frisket.invalid/page/"
+
+var contentsTemplate = template.Must(template.New("contents").Parse(contentsHTML))
+
+type contentsEntry struct {
+	Title string
+	Path  string
+	Type  string
+	Page  int
+}
+
+type contentsData struct {
+	Entries    []contentsEntry
+	LinkPrefix string // Entries are only wrapped in links while measuring
+}
+
+// Renders the table of contents for the documents. The page numbers depend on how long the contents
+// turn out to be, so it is rendered until its page count settles. following is the number of pages
+// placed between the contents and the first document.
+func renderContents(docs []*document, following int) (*section, error) {
+	pages := 1
+	for attempt := 0; attempt < 3; attempt++ {
+		entries := contentsEntries(docs, pages, following)
+
+		measured := "processing/contents-links.pdf"
+		if err := renderTemplate(contentsTemplate, contentsData{entries, contentsLinkPrefix}, "processing/contents-links.html", measured); err != nil {
+			return nil, err
+		}
+		count, err := pageCount(measured)
+		if err != nil {
+			return nil, err
+		}
+		if count != pages {
+			pages = count
+			continue
+		}
+
+		output := "processed/contents.pdf"
+		if err = renderTemplate(contentsTemplate, contentsData{entries, ""}, "processing/contents.html", output); err != nil {
+			return nil, err
+		}
+		contents := &section{File: output, Title: contentsTitle, PageCount: count}
+		measurements, err := readQpdfDocument(measured)
+		if err != nil {
+			errLog.Printf("Could not read the contents links, err: %v", err)
+			return contents, nil
+		}
+		for _, l := range measurements.links() {
+			if page, err := strconv.Atoi(strings.TrimPrefix(l.URI, contentsLinkPrefix)); err == nil {
+				contents.Links = append(contents.Links, link{SourcePage: l.Page, Rect: l.Rect, Page: page})
+			}
+		}
+		return contents, nil
+	}
+	return nil, fmt.Errorf("Table of contents did not settle on a page count")
+}
+
+// Lays the documents out after contents of the given number of pages and the following pages, returning an entry
+// for each document in the output
+func contentsEntries(docs []*document, pages, following int) []contentsEntry {
+	layoutPages(docs, pages+following+1)
+	entries := []contentsEntry{}
+	for _, doc := range docs {
+		if doc.Output != "" {
+			entries = append(entries, contentsEntry{Title: doc.Title, Path: doc.Path, Type: doc.Type, Page: doc.FirstPage})
+		}
+	}
+	return entries
+}
+
+// Executes the template into an HTML file and renders it with wkhtmltopdf
+func renderTemplate(t *template.Template, data interface{}, html, output string, args ...string) error {
+	page, err := createFile(html)
+	if err != nil {
+		return err
+	}
+	err = t.Execute(page, data)
+	page.Close()
+	if err != nil {
+		return err
+	}
+	return wkhtmltopdf(html, output, args...)
+}
+
+func wkhtmltopdf(html, output string, args ...string) error {
+	in, err := os.Open(html)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := createFile(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	cmd := exec.Command("wkhtmltopdf", append(append([]string{"--quiet"}, args...), "-", "-")...)
+	cmd.Stdin = in
+	cmd.Stdout = out
+	return run(cmd)
+}
+
+const contentsHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style type="text/css">
+body { font-family: Verdana, Arial, Helvetica, "PT Sans", sans-serif; font-size: 10pt; }
+h2 { font-size: 15pt; }
+table { width: 100%; border-collapse: collapse; }
+td { padding: 4px 2px; border-bottom: 1px solid #cccccc; vertical-align: top; }
+td.page { text-align: right; white-space: nowrap; }
+.path, .type { color: #666666; font-size: 8pt; }
+a { color: inherit; text-decoration: none; display: block; }
+</style>
+</head>
+<body>
+<h2>Contents</h2>
+<table>
+{{range .Entries}}<tr>
+<td>{{if $.LinkPrefix}}<a href="{{$.LinkPrefix}}{{.Page}}">{{else}}<a>{{end}}{{.Title}}<div class="path">{{.Path}}</div><div class="type">{{.Type}}</div></a></td>
+<td class="page">{{.Page}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestContentsEntries(t *testing.T) {
+	docs := []*document{
+		{Title: "Intro", Path: "intro.pdf", Type: "application/pdf", Output: "1.pdf", PageCount: 2},
+		{Title: "Missing", Path: "missing.doc"},
+		{Title: "Appendix", Path: "appendix/a/b.html", Type: "text/html", Output: "3.pdf", PageCount: 4},
+	}
+	// Two pages of contents and a page of summary in front of the documents
+	entries := contentsEntries(docs, 2, 1)
+	if len(entries) != 2 || entries[0].Page != 4 || entries[1].Page != 6 || entries[1].Path != "appendix/a/b.html" {
+		t.Fatalf("Incorrect entries, got %+v", entries)
+	}
+
+	// The documents' own outlines are nested under the pages the contents give them
+	outline := []qpdfOutline{{Title: "Part", Page: 1, Kids: []qpdfOutline{{Title: "Section", Page: 3}}}}
+	marks := offsetOutline(outline, docs[2].FirstPage-1, docs[2].PageCount)
+	if len(marks) != 1 || marks[0].Page != 6 || len(marks[0].Kids) != 1 || marks[0].Kids[0].Page != 8 {
+		t.Errorf("Incorrect nested bookmarks, got %+v", marks)
+	}
+
+	var measured, final bytes.Buffer
+	contentsTemplate.Execute(&measured, contentsData{entries, contentsLinkPrefix})
+	contentsTemplate.Execute(&final, contentsData{entries, ""})
+	for _, expected := range []string{`<a href="` + contentsLinkPrefix + `4">Intro`, `<a href="` + contentsLinkPrefix + `6">Appendix`, `<td class="page">6</td>`} {
+		if !strings.Contains(measured.String(), expected) {
+			t.Errorf("Expected %q in %s", expected, measured.String())
+		}
+	}
+	if strings.Contains(final.String(), "href") || !strings.Contains(final.String(), `<td class="page">4</td>`) {
+		t.Errorf("Expected the final contents to number pages without links, got %s", final.String())
+	}
+}
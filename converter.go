@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+var convertersPath = flag.String("converters", "", "JSON file describing external converters to register alongside the built in ones")
+
+// Turns documents of the types it handles into PDFs. Convert writes the PDF to output, returning a *processingError
+// when the whole job should fail and any other error when only the document failed.
+type converter interface {
+	Name() string
+	Types() []string // Content types such as text/html, image/* for a whole family or * for anything
+	Priority() int   // When several converters handle a type the highest priority wins
+	Convert(doc *document, output string, sp opentracing.Span) error
+}
+
+// Registered converters, earlier converters winning ties of priority
+var converters = []converter{
+	passthroughConverter{}, wkhtmltopdfConverter{}, chromiumConverter{},
+	textConverter, markdownConverter, csvConverter, jsonConverter, calcConverter{}, impressConverter{},
+	libreofficeConverter{},
+}
+
+func registerConverter(c converter) {
+	converters = append(converters, c)
+}
+
+// The converter for a content type, the preferred converter when it handles the type and otherwise
+// the one with the highest priority, nil when nothing handles it
+func converterFor(content, preferred string) converter {
+	var best converter
+	for _, c := range converters {
+		if !handles(c, content) {
+			continue
+		}
+		if c.Name() == preferred {
+			return c
+		}
+		if best == nil || c.Priority() > best.Priority() {
+			best = c
+		}
+	}
+	return best
+}
+
+// Content types recognised by extension, by the type sniffed, which only sees text, a zip or an OLE container
+var extensionTypes = map[string]map[string]string{
+	"text/plain": {
+		".md":       "text/markdown",
+		".markdown": "text/markdown",
+		".csv":      "text/csv",
+		".json":     "application/json",
+		".rtf":      "application/rtf",
+	},
+	"application/zip": {
+		".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		".xlsm": "application/vnd.ms-excel.sheet.macroEnabled.12",
+		".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+		".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		".odp":  "application/vnd.oasis.opendocument.presentation",
+	},
+	"application/octet-stream": {
+		".xls": "application/vnd.ms-excel",
+		".ppt": "application/vnd.ms-powerpoint",
+	},
+}
+
+// Refines a sniffed type by the file's extension
+func refineType(content, p string) string {
+	if refined, ok := extensionTypes[content][strings.ToLower(path.Ext(p))]; ok {
+		return refined
+	}
+	return content
+}
+
+// The type a document is converted as, its type option or else its sniffed type
+func documentType(doc *document) string {
+	if content, ok := doc.Options["type"]; ok {
+		return content
+	}
+	content, _ := getFileType(doc.Source)
+	return refineType(content, doc.Path)
+}
+
+// How long a tool may spend on the document, which the manifest's timeout option overrides
+func documentTimeout(doc *document, fallback time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(doc.Options["timeout"]); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return fallback
+}
+
+func handles(c converter, content string) bool {
+	for _, t := range c.Types() {
+		if t == "*" || t == content || strings.HasSuffix(t, "/*") && strings.HasPrefix(content, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs the command in its own process group, killing the group if it outlives the timeout
+func runWithTimeout(cmd *exec.Cmd, timeout time.Duration, network bool) error {
+	var stderr bytes.Buffer
+	name := filepath.Base(cmd.Path)
+	cmd.Stderr = &stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cleanup, err := sandbox(cmd, network)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case <-time.After(timeout):
+		pgid, _ := syscall.Getpgid(cmd.Process.Pid)
+		if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+			fatalLog.Fatal("failed to kill: ", err)
+		}
+		<-done
+		return &toolError{fmt.Errorf("%s did not finish within %v", name, timeout), stderr.String(), true, ""}
+	case err := <-done:
+		if err != nil {
+			return &toolError{err, stderr.String(), false, resourceLimit(cmd.ProcessState)}
+		}
+	}
+	return nil
+}
+
+// PDFs need no converting
+type passthroughConverter struct{}
+
+func (passthroughConverter) Name() string    { return "passthrough" }
+func (passthroughConverter) Types() []string { return []string{"application/pdf"} }
+func (passthroughConverter) Priority() int   { return 0 }
+
+func (passthroughConverter) Convert(doc *document, output string, sp opentracing.Span) error {
+	return os.Link(doc.Source, output)
+}
+
+type wkhtmltopdfConverter struct{}
+
+func (wkhtmltopdfConverter) Name() string    { return "wkhtmltopdf" }
+func (wkhtmltopdfConverter) Types() []string { return []string{"text/html", "text/htm"} }
+func (wkhtmltopdfConverter) Priority() int   { return 0 }
+
+// Renders the page from the bundle server with local file access limited to the header and footer, fetching everything else through it.
+// Resources that fail to load, such as those the bundle server blocks, are left out rather than failing the page, and
+// become warnings on the document.
+func (wkhtmltopdfConverter) Convert(doc *document, output string, sp opentracing.Span) error {
+	header, footer, err := doc.HTML.writeHeaderFooter(doc)
+	if err != nil {
+		return err
+	}
+	allow := []string{}
+	for _, file := range []string{header, footer} {
+		if file == "" {
+			continue
+		}
+		dir, err := filepath.Abs(filepath.Dir(file))
+		if err != nil {
+			return err
+		}
+		allow = append(allow, "--allow", dir)
+	}
+	return fromScratch(output, func(rendered string) error {
+		return withBundle(doc, func(bundle *bundleServer) error {
+			args := append([]string{"--quiet", "--disable-local-file-access", "--load-error-handling", "ignore", "--load-media-error-handling", "ignore",
+				"--proxy", bundle.URL}, doc.HTML.wkhtmltopdfArgs(header, footer)...)
+			cmd := exec.Command("wkhtmltopdf", append(append(args, allow...), bundle.documentURL(doc.Path), rendered)...)
+			return runWithTimeout(cmd, documentTimeout(doc, 60*time.Second), true)
+		})
+	})
+}
+
+// Everything else is left to LibreOffice
+type libreofficeConverter struct{}
+
+func (libreofficeConverter) Name() string    { return "libreoffice" }
+func (libreofficeConverter) Types() []string { return []string{"*"} }
+func (libreofficeConverter) Priority() int   { return -1 }
+
+func (libreofficeConverter) Convert(doc *document, output string, sp opentracing.Span) error {
+	documentConvertSp := opentracing.StartSpan("Libreoffice converting", opentracing.ChildOf(sp.Context()))
+	defer documentConvertSp.Finish()
+	return libre(doc, output)
+}
+
+// An external converter described in the -converters file
+type converterConfig struct {
+	Name     string   `json:"name"`
+	Types    []string `json:"types"`
+	Priority int      `json:"priority"`
+	Command  []string `json:"command"` // The program and its arguments, with {input} and {output} replaced by the files
+	Timeout  int      `json:"timeout"` // Seconds, 60 by default
+}
+
+type externalConverter struct {
+	config converterConfig
+}
+
+func (c externalConverter) Name() string    { return c.config.Name }
+func (c externalConverter) Types() []string { return c.config.Types }
+func (c externalConverter) Priority() int   { return c.config.Priority }
+
+// Runs the command template, taking the PDF from stdout when the template has no {output}
+func (c externalConverter) Convert(doc *document, output string, sp opentracing.Span) error {
+	timeout := 60 * time.Second
+	if c.config.Timeout > 0 {
+		timeout = time.Duration(c.config.Timeout) * time.Second
+	}
+	timeout = documentTimeout(doc, timeout)
+	convertSp := opentracing.StartSpan(c.config.Name+" converting", opentracing.ChildOf(sp.Context()))
+	defer convertSp.Finish()
+	command := func(output string) *exec.Cmd {
+		args := []string{}
+		for _, arg := range c.config.Command {
+			args = append(args, strings.NewReplacer("{input}", doc.Source, "{output}", output).Replace(arg))
+		}
+		return exec.Command(args[0], args[1:]...)
+	}
+	for _, arg := range c.config.Command {
+		if strings.Contains(arg, "{output}") {
+			return fromScratch(output, func(converted string) error {
+				return runWithTimeout(command(converted), timeout, false)
+			})
+		}
+	}
+	out, err := createFile(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	cmd := command(output)
+	cmd.Stdout = out
+	return runWithTimeout(cmd, timeout, false)
+}
+
+// Registers the external converters, a broken file stops the worker from starting
+func initConverters() {
+	if *convertersPath == "" {
+		return
+	}
+	contents, err := ioutil.ReadFile(*convertersPath)
+	if err != nil {
+		fatalLog.Fatalf("Cannot read the converters: %v", err)
+	}
+	configs, err := parseConverters(contents)
+	if err != nil {
+		fatalLog.Fatalf("Cannot parse the converters: %v", err)
+	}
+	for _, config := range configs {
+		infoLog.Printf("Registered converter %s for %s\n", config.Name, strings.Join(config.Types, ", "))
+		registerConverter(externalConverter{config})
+	}
+}
+
+func parseConverters(contents []byte) ([]converterConfig, error) {
+	configs := []converterConfig{}
+	if err := json.Unmarshal(contents, &configs); err != nil {
+		return nil, err
+	}
+	for i, config := range configs {
+		if config.Name == "" || len(config.Types) == 0 || len(config.Command) == 0 {
+			return nil, fmt.Errorf("converter %d needs a name, types and a command", i+1)
+		}
+		if !strings.Contains(strings.Join(config.Command, " "), "{input}") {
+			return nil, fmt.Errorf("the command of converter %s has no {input}", config.Name)
+		}
+	}
+	return configs, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+func TestConverterFor(t *testing.T) {
+	defer func(registered []converter) { converters = registered }(converters)
+	registerConverter(externalConverter{converterConfig{Name: "images", Types: []string{"image/*"}, Command: []string{"img2pdf", "{input}"}}})
+	registerConverter(externalConverter{converterConfig{Name: "fancy-html", Types: []string{"text/html"}, Priority: 10, Command: []string{"chrome", "{input}"}}})
+	registerConverter(externalConverter{converterConfig{Name: "tie", Types: []string{"application/pdf"}, Command: []string{"cp", "{input}", "{output}"}}})
+	for content, expected := range map[string]string{
+		"application/pdf":          "passthrough",
+		"text/html":                "fancy-html",
+		"text/htm":                 "wkhtmltopdf",
+		"image/png":                "images",
+		"application/octet-stream": "libreoffice",
+	} {
+		if c := converterFor(content, ""); c == nil || c.Name() != expected {
+			t.Errorf("Expected %v to convert %v, got %v", expected, content, c)
+		}
+	}
+}
+
+func TestParseConverters(t *testing.T) {
+	configs, err := parseConverters([]byte(`[{"name": "svg", "types": ["image/svg+xml"], "priority": 5, "command": ["rsvg-convert", "-f", "pdf", "-o", "{output}", "{input}"], "timeout": 30}]`))
+	if err != nil || len(configs) != 1 || configs[0].Priority != 5 || configs[0].Timeout != 30 {
+		t.Fatalf("Incorrect converters, got %+v %v", configs, err)
+	}
+	for _, contents := range []string{
+		`[{"types": ["image/svg+xml"], "command": ["convert", "{input}"]}]`,
+		`[{"name": "svg", "command": ["convert", "{input}"]}]`,
+		`[{"name": "svg", "types": ["image/svg+xml"], "command": ["convert", "in.svg"]}]`,
+		`{"name": "svg"}`,
+	} {
+		if _, err := parseConverters([]byte(contents)); err == nil {
+			t.Errorf("Expected an error for %s", contents)
+		}
+	}
+}
+
+func TestExternalConverter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	os.Mkdir("processing", 0755)
+	input := filepath.Join(dir, "in.txt")
+	ioutil.WriteFile(input, []byte("%PDF-1.4"), 0644)
+	doc := &document{Source: input}
+	sp := opentracing.StartSpan("test")
+
+	output := filepath.Join(dir, "copied.pdf")
+	if err := (externalConverter{converterConfig{Name: "cp", Command: []string{"cp", "{input}", "{output}"}}}).Convert(doc, output, sp); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if contents, _ := ioutil.ReadFile(output); string(contents) != "%PDF-1.4" {
+		t.Errorf("Expected the input to be copied, got %q", contents)
+	}
+
+	output = filepath.Join(dir, "stdout.pdf")
+	if err := (externalConverter{converterConfig{Name: "cat", Command: []string{"cat", "{input}"}}}).Convert(doc, output, sp); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if contents, _ := ioutil.ReadFile(output); string(contents) != "%PDF-1.4" {
+		t.Errorf("Expected stdout to become the output, got %q", contents)
+	}
+
+	doc.Options = map[string]string{"timeout": "1"}
+	err = (externalConverter{converterConfig{Name: "sleep", Command: []string{"sleep", "5"}}}).Convert(doc, output, sp)
+	if te, ok := err.(*toolError); !ok || !te.timedOut {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
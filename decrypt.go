@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+var decryptScript = flag.String("decrypt-script", "/usr/local/lib/frisket/decrypt.py", "Script decrypting Office documents through msoffcrypto-tool's API")
+
+var errEncrypted = errors.New("no password opens the file")
+var errEncryptedArchive = errors.New("encrypted archives are not opened")
+
+// Office documents are OLE compound files, and a password protected OOXML document keeps its zip in an EncryptedPackage stream
+var oleMagic = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+var encryptedPackage = utf16le("EncryptedPackage")
+
+func utf16le(s string) []byte {
+	b := []byte{}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return b
+}
+
+// Whether the PDF is encrypted. qpdf 9 has no --is-encrypted, so this goes by --show-encryption, which says when
+// a file is not encrypted and fails on one that needs a password.
+func encryptedPDF(file string) bool {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("qpdf", "--show-encryption", file)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := runQuietly(cmd); err != nil && !qpdfWarned(err) {
+		return strings.Contains(stderr.String(), "invalid password")
+	}
+	return !strings.Contains(stdout.String(), "File is not encrypted")
+}
+
+// Whether any entry of the zip file is encrypted. Encrypted OOXML is an OLE file rather than a zip, so this only
+// finds plain archives.
+func encryptedArchive(file string) bool {
+	r, err := zip.OpenReader(file)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Flags&0x1 != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// The qpdf options that open a file with the password, kept in an argument file in the scratch directory so the
+// password stays off the command line. Arguments in the file are one to a line.
+func passwordArgs(scratch scratchDir, password string) (string, error) {
+	if strings.ContainsAny(password, "\r\n") {
+		return "", errors.New("passwords cannot contain line breaks")
+	}
+	file := scratch.path("password.args")
+	return "@" + file, writeFile(file, []byte("--password="+password+"\n"))
+}
+
+// Checks the qpdf on the path can be run, and is 9 or later for --show-encryption and argument files
+func initQpdf() {
+	var out bytes.Buffer
+	cmd := exec.Command("qpdf", "--version")
+	cmd.Stdout = &out
+	if err := run(cmd); err != nil {
+		fatalLog.Fatalf("Cannot run qpdf: %v", err)
+	}
+	var major int
+	if _, err := fmt.Sscanf(out.String(), "qpdf version %d.", &major); err != nil || major < 9 {
+		fatalLog.Fatalf("qpdf 9 or later is needed, got %q", strings.SplitN(out.String(), "\n", 2)[0])
+	}
+}
+
+func encryptedOffice(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	header := make([]byte, len(oleMagic))
+	if _, err = io.ReadFull(f, header); err != nil || !bytes.Equal(header, oleMagic) {
+		return false, nil
+	}
+	contents, err := ioutil.ReadAll(f)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Contains(contents, encryptedPackage), nil
+}
+
+// Decrypts an encrypted PDF or Office document into processing/decrypted-<id>/, trying an empty password for PDFs
+// that only restrict permissions, then each of the job's passwords. The decrypted copy becomes the document's source.
+// The passwords are given in an argument file or on standard input, out of sight of ps, and the commands are run with
+// runQuietly so they stay out of the logs. Encrypted zip archives are only detected, as nothing converts an archive.
+func decrypt(doc *document, content string, passwords []string) (bool, error) {
+	if content == "application/zip" && encryptedArchive(doc.Source) {
+		return true, errEncryptedArchive
+	}
+	pdf := content == "application/pdf" && encryptedPDF(doc.Source)
+	office := false
+	if !pdf {
+		var err error
+		if office, err = encryptedOffice(doc.Source); err != nil {
+			return false, err
+		}
+	}
+	if !pdf && !office {
+		return false, nil
+	}
+	dir := fmt.Sprintf("processing/decrypted-%d", doc.id)
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return true, err
+	}
+	name := filepath.Base(doc.Source)
+	scratch, err := newScratch()
+	if err != nil {
+		return true, err
+	}
+	defer scratch.remove()
+	if pdf {
+		passwords = append([]string{""}, passwords...)
+	}
+	for _, password := range passwords {
+		var cmd *exec.Cmd
+		if pdf {
+			args, err := passwordArgs(scratch, password)
+			if err != nil {
+				continue
+			}
+			cmd = exec.Command("qpdf", args, "--decrypt", doc.Source, scratch.path(name))
+		} else {
+			cmd = exec.Command("python3", *decryptScript, "--input", doc.Source, "--output", scratch.path(name))
+			cmd.Stdin = strings.NewReader(password)
+		}
+		if err := runQuietly(cmd); err == nil || pdf && qpdfWarned(err) {
+			output := filepath.Join(dir, name)
+			if err := scratch.collect(name, output); err != nil {
+				return true, err
+			}
+			infoLog.Printf("%s decrypted\n", doc.Path)
+			doc.Source = output
+			return true, nil
+		}
+	}
+	return true, errEncrypted
+}
@@ -0,0 +1,33 @@
+#!/usr/bin/env python3
+"""Decrypts a password protected Office document with msoffcrypto-tool's API.
+
+The password is read from standard input so it never appears on a command line.
+"""
+
+import argparse
+import sys
+
+import msoffcrypto
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__)
+    parser.add_argument("--input", required=True)
+    parser.add_argument("--output", required=True)
+    args = parser.parse_args()
+
+    password = sys.stdin.read()
+    try:
+        with open(args.input, "rb") as source:
+            document = msoffcrypto.OfficeFile(source)
+            document.load_key(password=password)
+            with open(args.output, "wb") as output:
+                document.decrypt(output)
+    except Exception as e:
+        print(e, file=sys.stderr)
+        return 1
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedOffice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string][]byte{
+		"encrypted.docx": append(append(append([]byte{}, oleMagic...), make([]byte, 100)...), encryptedPackage...),
+		"legacy.doc":     append(append([]byte{}, oleMagic...), utf16le("WordDocument")...),
+		"plain.docx":     append([]byte("PK\x03\x04"), encryptedPackage...),
+	}
+	expected := map[string]bool{"encrypted.docx": true, "legacy.doc": false, "plain.docx": false}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, contents, 0644)
+		if encrypted, err := encryptedOffice(path); err != nil || encrypted != expected[name] {
+			t.Errorf("Expected %v to be encrypted %v, got %v %v", name, expected[name], encrypted, err)
+		}
+	}
+
+	doc := &document{Path: "plain.docx", Source: filepath.Join(dir, "plain.docx")}
+	if decrypted, err := decrypt(doc, "application/zip", []string{"secret"}); decrypted || err != nil {
+		t.Errorf("Expected an unencrypted document to be left alone, got %v %v", decrypted, err)
+	}
+}
+
+func TestEncryptedArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, flags := range map[string]uint16{"plain.zip": 0, "encrypted.zip": 0x1} {
+		f, _ := os.Create(filepath.Join(dir, name))
+		w := zip.NewWriter(f)
+		entry, _ := w.CreateHeader(&zip.FileHeader{Name: "a.txt", Flags: flags})
+		entry.Write([]byte("a"))
+		w.Close()
+		f.Close()
+	}
+	if encryptedArchive(filepath.Join(dir, "plain.zip")) || !encryptedArchive(filepath.Join(dir, "encrypted.zip")) {
+		t.Error("Expected only the archive with an encrypted entry to be encrypted")
+	}
+	doc := &document{Path: "encrypted.zip", Source: filepath.Join(dir, "encrypted.zip")}
+	if decrypted, err := decrypt(doc, "application/zip", []string{"secret"}); !decrypted || err != errEncryptedArchive {
+		t.Errorf("Expected an encrypted archive, got %v %v", decrypted, err)
+	}
+}
+
+func TestPasswordArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	args, err := passwordArgs(scratchDir(dir), "se cret")
+	if err != nil || args != "@"+filepath.Join(dir, "password.args") {
+		t.Fatalf("Incorrect argument file, got %q %v", args, err)
+	}
+	if contents, _ := ioutil.ReadFile(args[1:]); string(contents) != "--password=se cret\n" {
+		t.Errorf("Incorrect arguments, got %q", contents)
+	}
+	if _, err := passwordArgs(scratchDir(dir), "a\nb"); err == nil {
+		t.Error("Expected an error for a password with a line break")
+	}
+}
+
+func TestUTF16LE(t *testing.T) {
+	if b := utf16le("Ab"); string(b) != "A\x00b\x00" {
+		t.Errorf("Incorrect encoding, got %q", b)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// How the converted documents are delivered
+const (
+	outputStitched  = "pdf"       // Stitched into <key>.pdf, the default
+	outputDocuments = "documents" // Uploaded one by one under <key>/
+	outputZip       = "zip"       // Collected in <key>.zip with the summary and the result
+)
+
+// Where a converted document goes under the prefix or in the zip, named after its path in the bundle
+func documentKey(path string) string {
+	if strings.ToLower(filepath.Ext(path)) != ".pdf" {
+		path += ".pdf"
+	}
+	return "documents/" + path
+}
+
+// Gives each converted document its own key, suffixing the document's id when paths such as a and a.pdf collide
+func assignKeys(docs []*document) {
+	used := map[string]bool{}
+	for _, doc := range docs {
+		if doc.Output == "" {
+			continue
+		}
+		key := documentKey(doc.Path)
+		ext := filepath.Ext(key)
+		stem := strings.TrimSuffix(key, ext)
+		for n := 0; used[key]; n++ {
+			key = fmt.Sprintf("%s-%d%s", stem, doc.id, ext)
+			if n > 0 {
+				key = fmt.Sprintf("%s-%d-%d%s", stem, doc.id, n, ext)
+			}
+		}
+		used[key] = true
+		doc.Delivered = key
+	}
+}
+
+// Job options that only apply to stitched output, by the name the job gives them
+func stitchingOptions(j *job) []string {
+	options := []string{}
+	for name, set := range map[string]bool{
+		"toc":              j.TOC,
+		"stamps":           len(j.Stamps) > 0,
+		"bates":            j.Bates != nil,
+		"watermark":        j.Watermark != nil,
+		"summary_position": j.SummaryPosition != "",
+		"pdfa":             j.PDFA != "",
+		"profile":          j.Profile != "",
+		"linearize":        j.Linearize,
+		"max_pages":        j.MaxPages > 0,
+		"max_bytes":        j.MaxBytes > 0,
+		"thumbnails":       j.Thumbnails != nil,
+		"text":             j.Text != "",
+	} {
+		if set {
+			options = append(options, name)
+		}
+	}
+	sort.Strings(options)
+	return options
+}
+
+// Delivers the converted documents without stitching them, uploading each under <key>/ or all of them in <key>.zip
+func deliverDocuments(j *job, docs []*document, result *jobResult, processSp opentracing.Span) *processingError {
+	stage := time.Now()
+	countPages(docs)
+	summary, err := renderSummary(j, docs)
+	if err != nil {
+		errLog.Printf("Could not render the summary, err: %v", err)
+	}
+	files := map[string]string{}
+	assignKeys(docs)
+	for _, doc := range docs {
+		if doc.Output == "" {
+			continue
+		}
+		files[doc.Delivered] = doc.Output
+	}
+	if summary != nil {
+		files["summary.pdf"] = summary.File
+	}
+	result.timed("layout", stage)
+
+	stage = time.Now()
+	if j.Output == outputDocuments {
+		result.Output = j.Key + "/"
+		for name, file := range files {
+			if perr := uploadFile(file, j.Key+"/"+name, "application/pdf", processSp); perr != nil {
+				return perr
+			}
+		}
+		result.timed("upload", stage)
+		result.finish(j, docs)
+		return uploadResult(result, processSp)
+	}
+
+	result.Output = j.Key + ".zip"
+	result.finish(j, docs)
+	body, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return &processingError{fmt.Errorf("Could not encode result, err: %v", err.Error()), 561}
+	}
+	output := "processed/" + j.Key + ".zip"
+	zipSp := opentracing.StartSpan("Zipping", opentracing.ChildOf(processSp.Context()))
+	err = writeZip(output, files, j.Key+".json", body)
+	zipSp.Finish()
+	if err != nil {
+		return &processingError{fmt.Errorf("Could not zip the converted documents, err: %v", err.Error()), 575}
+	}
+	if perr := uploadFile(output, result.Output, "application/zip", processSp); perr != nil {
+		return perr
+	}
+	return uploadResult(result, processSp)
+}
+
+// Writes the files to a zip under their names, followed by the job's result
+func writeZip(output string, files map[string]string, resultName string, result []byte) error {
+	out, err := createFile(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		in, err := os.Open(files[name])
+		if err != nil {
+			return err
+		}
+		entry, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err == nil {
+			_, err = io.Copy(entry, in)
+		}
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	entry, err := w.CreateHeader(&zip.FileHeader{Name: resultName, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	if _, err = entry.Write(result); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDocumentKey(t *testing.T) {
+	for path, expected := range map[string]string{
+		"a.pdf":           "documents/a.pdf",
+		"dir/Report.PDF":  "documents/dir/Report.PDF",
+		"dir/letter.docx": "documents/dir/letter.docx.pdf",
+		"notes":           "documents/notes.pdf",
+	} {
+		if key := documentKey(path); key != expected {
+			t.Errorf("Expected %v for %v, got %v", expected, path, key)
+		}
+	}
+}
+
+func TestAssignKeys(t *testing.T) {
+	docs := []*document{
+		{id: 0, Path: "a", Output: "processed/0.pdf"},
+		{id: 1, Path: "a.pdf", Output: "processed/1.pdf"},
+		{id: 2, Path: "b.docx"},
+		{id: 3, Path: "a-1.pdf", Output: "processed/3.pdf"},
+	}
+	assignKeys(docs)
+	expected := []string{"documents/a.pdf", "documents/a-1.pdf", "", "documents/a-1-3.pdf"}
+	for i, doc := range docs {
+		if doc.Delivered != expected[i] {
+			t.Errorf("Expected %q for %s, got %q", expected[i], doc.Path, doc.Delivered)
+		}
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.pdf")
+	ioutil.WriteFile(a, []byte("%PDF-a"), 0644)
+	output := filepath.Join(dir, "bundle.zip")
+	if err := writeZip(output, map[string]string{"summary.pdf": a, "documents/a.pdf": a}, "bundle.json", []byte("{}")); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	r, err := zip.OpenReader(output)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer r.Close()
+	expected := []string{"documents/a.pdf", "summary.pdf", "bundle.json"}
+	if len(r.File) != len(expected) {
+		t.Fatalf("Expected %v, got %d entries", expected, len(r.File))
+	}
+	for i, f := range r.File {
+		if f.Name != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], f.Name)
+		}
+	}
+}
+
+func TestParseJobOutput(t *testing.T) {
+	if j, err := parseJob(`{"key": "bundle", "output": "zip"}`); err != nil || j.Output != outputZip {
+		t.Errorf("Expected zip output, got %+v %v", j, err)
+	}
+	if _, err := parseJob(`{"key": "bundle", "output": "tar"}`); err == nil {
+		t.Error("Expected an error for an unknown output mode")
+	}
+	_, err := parseJob(`{"key": "bundle", "output": "documents", "stamps": [{"text": "{page}"}], "pdfa": "2b"}`)
+	if err == nil || !strings.Contains(err.Error(), "pdfa, stamps") {
+		t.Errorf("Expected an error naming the stitching options, got %v", err)
+	}
+	if _, err := parseJob(`{"key": "bundle", "output": "zip", "ocr": {}, "passwords": ["secret"]}`); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What became of a document
+const (
+	statusConverted = "converted"
+	statusFailed    = "failed"
+	statusSkipped   = "skipped"
+	statusEmbedded  = "embedded"
+)
+
+// Why a document is missing from the output
+const (
+	reasonTimeout     = "timeout"
+	reasonExitCode    = "exit code"
+	reasonUnsupported = "unsupported type"
+	reasonRule        = "extraction rule"
+	reasonMissing     = "missing"
+	reasonEncrypted   = "encrypted"
+	reasonDamaged     = "damaged"
+	reasonLimit       = "resource limit"
+	reasonError       = "error"
+)
+
+// How much of a tool's stderr is kept for the summary
+const stderrExcerpt = 500
+
+// A single file from the tarball and what became of it
+type document struct {
+	id       int
+	Path     string            // Path inside the tarball
+	Source   string            // Extracted file on disk, empty if the manifest named a file the tarball lacks
+	Size     int64             // Size in the tarball
+	SHA256   string            // Hex digest of the file's contents
+	Output   string            // Converted PDF, empty until converted
+	Title    string            // Display title, defaults to the path
+	Type     string            // Detected content type
+	Encoding string            // Character encoding text was transcoded to UTF-8 from
+	Pages    string            // Page selection applied after conversion
+	Options  map[string]string // Converter options from the manifest
+	HTML     htmlOptions       // How HTML is rendered, from the job and the manifest
+
+	Spreadsheet  spreadsheetOptions  // How spreadsheets are laid out, from the job and the manifest
+	Presentation presentationOptions // How presentations are printed, from the job and the manifest
+
+	htmlConverter string      // Converter printing HTML, from the job or -html-converter
+	usedBy        []*document // Pages and stylesheets pulling the document in
+
+	Converter string        // What turned the document into a PDF
+	Duration  time.Duration // Time spent converting the document
+	Status    string        // Empty until the document is converted, failed or skipped
+	Reason    string        // Why the document is not in the output
+	Detail    string        // The exit code, rule or error behind the reason
+	Stderr    string        // Excerpt of what the failing tool wrote to stderr
+
+	FirstPage int // Where the document starts in the stitched PDF
+	PageCount int
+	Delivered string // Key or zip entry the converted PDF was delivered under when not stitched
+	Repaired  bool   // The converted PDF was damaged and rewritten
+
+	OCRPages      int     // Pages given a text layer by OCR
+	OCRConfidence float64 // Mean confidence of the recognised words, from 0 to 100
+
+	Warnings []string // Problems that did not stop the document, such as blocked requests
+}
+
+// An external tool that failed, carrying what it wrote to stderr
+type toolError struct {
+	error
+	stderr   string
+	timedOut bool
+	limit    string // The sandbox's resource limit the tool ran into
+}
+
+func (doc *document) converted(output string) {
+	doc.Output = output
+	doc.Status = statusConverted
+}
+
+// Leaves the document to be rendered as part of an HTML document rather than on its own
+func (doc *document) embed(by *document) {
+	for _, user := range doc.usedBy {
+		if user == by {
+			return
+		}
+	}
+	if doc.Status != statusEmbedded {
+		doc.Status = statusEmbedded
+		doc.Detail = "used by " + by.Path
+	}
+	doc.usedBy = append(doc.usedBy, by)
+}
+
+// Notes a problem that did not stop the document, once
+func (doc *document) warn(warning string) {
+	for _, w := range doc.Warnings {
+		if w == warning {
+			return
+		}
+	}
+	doc.Warnings = append(doc.Warnings, warning)
+}
+
+// Leaves the document out of the output by choice rather than failure
+func (doc *document) exclude(reason, detail string) {
+	doc.Output = ""
+	doc.Status = statusSkipped
+	doc.Reason = reason
+	doc.Detail = detail
+}
+
+// Leaves the document out of the output, keeping what went wrong for the summary
+func (doc *document) fail(err error) {
+	infoLog.Printf("%s failed, err: %v\n", doc.Path, err)
+	doc.Output = ""
+	doc.Status = statusFailed
+	doc.Reason = reasonError
+	doc.Detail = err.Error()
+	te, ok := err.(*toolError)
+	if !ok {
+		return
+	}
+	doc.Stderr = excerpt(te.stderr)
+	if te.timedOut {
+		doc.Reason = reasonTimeout
+	} else if te.limit != "" {
+		doc.Reason = reasonLimit
+		doc.Detail = te.limit
+	} else if exit, ok := te.error.(*exec.ExitError); ok {
+		doc.Reason = reasonExitCode
+		doc.Detail = strconv.Itoa(exit.ExitCode())
+	}
+}
+
+// Leaves out a converted PDF that is beyond repair or stops the stitch
+func (doc *document) damaged(err error, detail string) {
+	doc.fail(err)
+	doc.Reason = reasonDamaged
+	doc.Detail = detail
+}
+
+// Whether the document was left out of the output
+func (doc *document) excluded() bool {
+	return doc.Status == statusFailed || doc.Status == statusSkipped
+}
+
+// Keeps the end of the text, where tools usually explain why they failed
+func excerpt(text string) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= stderrExcerpt {
+		return string(runes)
+	}
+	return "…" + string(runes[len(runes)-stderrExcerpt:])
+}
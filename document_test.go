@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestDocumentFail(t *testing.T) {
+	doc := &document{Path: "a.docx", Output: "processed/0.pdf", Status: statusConverted}
+	doc.fail(errors.New("no space"))
+	if doc.Output != "" || doc.Status != statusFailed || doc.Reason != reasonError || doc.Detail != "no space" {
+		t.Errorf("Incorrect failure, got %+v", doc)
+	}
+
+	doc = &document{Path: "a.docx"}
+	doc.fail(&toolError{errors.New("killed"), "still going", true, ""})
+	if doc.Reason != reasonTimeout || doc.Stderr != "still going" {
+		t.Errorf("Expected a timeout, got %+v", doc)
+	}
+
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	doc = &document{Path: "a.docx"}
+	doc.fail(&toolError{err, "", false, ""})
+	if doc.Reason != reasonExitCode || doc.Detail != "3" {
+		t.Errorf("Expected exit code 3, got %+v", doc)
+	}
+
+	doc = &document{Path: "a.docx"}
+	doc.fail(&toolError{err, "", false, "cpu time"})
+	if doc.Reason != reasonLimit || doc.Detail != "cpu time" {
+		t.Errorf("Expected the CPU limit, got %+v", doc)
+	}
+}
+
+func TestDocumentExclude(t *testing.T) {
+	doc := &document{Path: "a.mp3"}
+	doc.exclude(reasonUnsupported, "audio/mpeg")
+	if !doc.excluded() || doc.Status != statusSkipped || doc.Detail != "audio/mpeg" {
+		t.Errorf("Incorrect exclusion, got %+v", doc)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	if e := excerpt("  short\n"); e != "short" {
+		t.Errorf("Expected the whole text, got %q", e)
+	}
+	long := strings.Repeat("a", stderrExcerpt) + "end"
+	if e := excerpt(long); !strings.HasSuffix(e, "end") || len([]rune(e)) != stderrExcerpt+1 {
+		t.Errorf("Expected the end of the text, got %q", e)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var htmlAllow = flag.String("html-allow", "", "Comma separated hosts HTML may fetch from, e.g. fonts.gstatic.com,https://*.example.com. Everything else is blocked")
+var htmlPorts = flag.String("html-ports", "80,443", "Comma separated ports HTML may fetch from on the allowed hosts")
+
+// A host HTML may fetch from, over any scheme when none is given
+type egressRule struct {
+	scheme string
+	host   string // May start with *. to match any subdomain
+}
+
+var egressRules []egressRule
+var egressPorts map[string]bool
+
+// Parses -html-allow and -html-ports, a bad entry stops the worker from starting
+func initEgress() {
+	rules, err := parseAllowlist(*htmlAllow)
+	if err != nil {
+		fatalLog.Fatalf("Cannot parse -html-allow: %v", err)
+	}
+	ports, err := parsePorts(*htmlPorts)
+	if err != nil {
+		fatalLog.Fatalf("Cannot parse -html-ports: %v", err)
+	}
+	egressRules, egressPorts = rules, ports
+}
+
+func parseAllowlist(list string) ([]egressRule, error) {
+	rules := []egressRule{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		rule := egressRule{host: entry}
+		if i := strings.Index(entry, "://"); i >= 0 {
+			rule.scheme, rule.host = entry[:i], entry[i+3:]
+		}
+		if rule.host == "" || rule.host == "*." || strings.ContainsAny(rule.host, "/:@ ") || strings.Contains(strings.TrimPrefix(rule.host, "*."), "*") {
+			return nil, fmt.Errorf("%q is not a host", entry)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parsePorts(list string) (map[string]bool, error) {
+	ports := map[string]bool{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		port, err := strconv.Atoi(entry)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("%q is not a port", entry)
+		}
+		ports[strconv.Itoa(port)] = true
+	}
+	return ports, nil
+}
+
+// Whether a URL's scheme and host are on the allowlist
+func allowed(rules []egressRule, scheme, host string) bool {
+	scheme, host = strings.ToLower(scheme), strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, rule := range rules {
+		if rule.scheme != "" && rule.scheme != scheme {
+			continue
+		}
+		if rule.host == host || strings.HasPrefix(rule.host, "*.") && strings.HasSuffix(host, rule.host[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Serves the extracted bundle on the loopback interface, so relative URLs in HTML resolve to files in the bundle
+// and a page served over HTTP cannot reach local files outside it. It is also the renderer's proxy, passing on
+// requests to allowed hosts and blocking the rest, which become warnings on the document once it is closed.
+type bundleServer struct {
+	URL     string
+	doc     *document
+	server  *http.Server
+	files   http.Handler
+	proxy   *httputil.ReverseProxy
+	lock    sync.Mutex
+	blocked []string
+}
+
+func serveBundle(doc *document) (*bundleServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &bundleServer{
+		URL:   "http://" + listener.Addr().String(),
+		doc:   doc,
+		files: http.FileServer(http.Dir(bundleDir)),
+		// Requests reach the proxy with absolute URLs, which are passed on as they are
+		proxy: &httputil.ReverseProxy{Director: func(*http.Request) {}},
+	}
+	s.server = &http.Server{Handler: s}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// Serves the bundle while render runs. With -sandbox-namespaces the server listens in a network namespace of its own
+// that render starts the renderer in, so the server is all the renderer can reach and nothing goes around its proxy,
+// such as WebRTC or a process ignoring the proxy settings. The server still fetches from allowed hosts outside.
+func withBundle(doc *document, render func(*bundleServer) error) error {
+	serve := func() error {
+		bundle, err := serveBundle(doc)
+		if err != nil {
+			return err
+		}
+		defer bundle.Close()
+		return render(bundle)
+	}
+	if *sandboxNamespaces {
+		return inNetworkNamespace(serve)
+	}
+	return serve()
+}
+
+// The URL a document in the bundle is served at
+func (s *bundleServer) documentURL(p string) string {
+	return s.URL + (&url.URL{Path: "/" + p}).EscapedPath()
+}
+
+func (s *bundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		s.tunnel(w, r)
+		return
+	}
+	if r.URL.Host == "" || "http://"+r.URL.Host == s.URL {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+	port := r.URL.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[r.URL.Scheme]
+	}
+	if !allowed(egressRules, r.URL.Scheme, r.URL.Hostname()) || !egressPorts[port] {
+		s.block(w, r.URL.String())
+		return
+	}
+	s.proxy.ServeHTTP(w, r)
+}
+
+// Passes on HTTPS, which arrives as a CONNECT to the host and port. Only the allowed ports are tunnelled, so an
+// allowed host cannot be used to reach its other services.
+func (s *bundleServer) tunnel(w http.ResponseWriter, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil || !allowed(egressRules, "https", host) || !egressPorts[port] {
+		s.block(w, "https://"+r.Host)
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", r.Host, 10*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "Tunnelling is not supported", http.StatusInternalServerError)
+		return
+	}
+	client, _, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	go func() {
+		io.Copy(upstream, client)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
+
+func (s *bundleServer) block(w http.ResponseWriter, target string) {
+	infoLog.Printf("Blocked %s requested by %s\n", target, s.doc.Path)
+	s.lock.Lock()
+	s.blocked = append(s.blocked, target)
+	s.lock.Unlock()
+	http.Error(w, "Blocked by the allowlist", http.StatusForbidden)
+}
+
+// Stops the server and warns about the requests it blocked
+func (s *bundleServer) Close() {
+	s.server.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, target := range s.blocked {
+		s.doc.warn("blocked request for " + target)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+func TestAllowed(t *testing.T) {
+	rules, err := parseAllowlist(" fonts.gstatic.com, https://*.Example.com ,")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	for _, c := range []struct {
+		scheme, host string
+		expected     bool
+	}{
+		{"https", "fonts.gstatic.com", true},
+		{"http", "fonts.gstatic.com", true},
+		{"https", "cdn.example.com", true},
+		{"https", "a.b.example.com.", true},
+		{"http", "cdn.example.com", false},
+		{"https", "example.com", false},
+		{"https", "badexample.com", false},
+		{"http", "169.254.169.254", false},
+	} {
+		if allowed(rules, c.scheme, c.host) != c.expected {
+			t.Errorf("Expected %s://%s allowed to be %v", c.scheme, c.host, c.expected)
+		}
+	}
+	for _, list := range []string{"https://", "*.", "example.com/path", "a*.example.com", "example.com:8080"} {
+		if _, err := parseAllowlist(list); err == nil {
+			t.Errorf("Expected an error for %q", list)
+		}
+	}
+	ports, err := parsePorts(" 443, 0080,")
+	if err != nil || !reflect.DeepEqual(ports, map[string]bool{"443": true, "80": true}) {
+		t.Errorf("Incorrect ports, got %v and %v", ports, err)
+	}
+	for _, list := range []string{"https", "0", "65536"} {
+		if _, err := parsePorts(list); err == nil {
+			t.Errorf("Expected an error for %q", list)
+		}
+	}
+}
+
+func TestBundleServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	os.MkdirAll(filepath.Join(bundleDir, "site"), 0755)
+	ioutil.WriteFile(filepath.Join(bundleDir, "site", "a page.html"), []byte("<p>Hello</p>"), 0644)
+	ioutil.WriteFile("secret.txt", []byte("secret"), 0644)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("remote"))
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+	defer func(rules []egressRule, ports map[string]bool) { egressRules, egressPorts = rules, ports }(egressRules, egressPorts)
+	egressRules = []egressRule{{"http", upstreamURL.Hostname()}, {"https", "localhost"}}
+	egressPorts = map[string]bool{"80": true, upstreamURL.Port(): true}
+
+	doc := &document{Path: "site/a page.html"}
+	bundle, err := serveBundle(doc)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	page := bundle.documentURL(doc.Path)
+	if !strings.HasSuffix(page, "/site/a%20page.html") {
+		t.Errorf("Incorrect URL, got %s", page)
+	}
+	proxy, _ := url.Parse(bundle.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+	get := func(target string) (int, string) {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if _, body := get(page); body != "<p>Hello</p>" {
+		t.Errorf("Expected the page, got %q", body)
+	}
+	if _, body := get(bundle.URL + "/../secret.txt"); body == "secret" {
+		t.Errorf("Expected files outside the bundle to be unreachable")
+	}
+	if _, body := get(upstream.URL + "/font.woff"); body != "remote" {
+		t.Errorf("Expected the allowed host to be reached, got %q", body)
+	}
+	if status, _ := get("http://169.254.169.254/latest/meta-data/"); status != http.StatusForbidden {
+		t.Errorf("Expected the metadata endpoint to be blocked, got %d", status)
+	}
+	get("http://169.254.169.254/latest/meta-data/")
+	connect := func(target string) string {
+		conn, err := net.Dial("tcp", proxy.Host)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n\r\n"))
+		status, _ := bufio.NewReader(conn).ReadString('\n')
+		return status
+	}
+	if status := connect("localhost:" + upstreamURL.Port()); !strings.Contains(status, " 200 ") {
+		t.Errorf("Expected an allowed port to be tunnelled, got %q", status)
+	}
+	if status := connect("localhost:6379"); !strings.Contains(status, " 403 ") {
+		t.Errorf("Expected other ports to be blocked, got %q", status)
+	}
+	bundle.Close()
+	expected := []string{"blocked request for http://169.254.169.254/latest/meta-data/", "blocked request for https://localhost:6379"}
+	if !reflect.DeepEqual(doc.Warnings, expected) {
+		t.Errorf("Expected %v, got %v", expected, doc.Warnings)
+	}
+}
+
+// Stands in for wkhtmltopdf, fetching a blocked image through the proxy and, like wkhtmltopdf, exiting with 1 on
+// the load error unless told to ignore it
+const fakeWkhtmltopdf = `#!/bin/bash
+while [ $# -gt 2 ]; do
+	case "$1" in
+	--proxy) proxy=${2#http://}; shift ;;
+	--load-media-error-handling) media=$2; shift ;;
+	esac
+	shift
+done
+exec 3<>/dev/tcp/${proxy%:*}/${proxy#*:}
+printf 'GET http://blocked.example/logo.png HTTP/1.0\r\nHost: blocked.example\r\n\r\n' >&3
+read -r status <&3
+echo "%PDF-1.4" > "$2"
+case "$status" in *403*) [ "$media" = ignore ] || exit 1 ;; esac
+`
+
+func TestWkhtmltopdfBlockedImage(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	for _, d := range []string{bundleDir, "processed", "bin"} {
+		os.MkdirAll(d, 0755)
+	}
+	ioutil.WriteFile("bin/wkhtmltopdf", []byte(fakeWkhtmltopdf), 0755)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", filepath.Join(dir, "bin")+":"+os.Getenv("PATH"))
+	source := filepath.Join(bundleDir, "page.html")
+	ioutil.WriteFile(source, []byte(`<html><body><img src="http://blocked.example/logo.png"></body></html>`), 0644)
+
+	doc := &document{id: 1, Path: "page.html", Source: source}
+	if perr := convertDocument(&job{HTMLConverter: "wkhtmltopdf"}, doc, opentracing.StartSpan("test")); perr != nil {
+		t.Fatalf("Unexpected error %v", perr)
+	}
+	if doc.Status != statusConverted || doc.Output == "" {
+		t.Errorf("Expected the page to be kept, got %+v", doc)
+	}
+	expected := []string{"blocked request for http://blocked.example/logo.png"}
+	if !reflect.DeepEqual(doc.Warnings, expected) {
+		t.Errorf("Expected %v, got %v", expected, doc.Warnings)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encodings recorded for text documents
+const (
+	encodingASCII   = "US-ASCII"
+	encodingUTF8    = "UTF-8"
+	encodingUTF16LE = "UTF-16LE"
+	encodingUTF16BE = "UTF-16BE"
+	encoding1252    = "windows-1252"
+)
+
+// Windows-1252 differs from Latin-1 in 0x80 to 0x9F, the bytes it leaves undefined map to the same control characters
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// Whether a type is text the converters read as UTF-8. HTML declares its own charset and is left to the browser.
+func textual(content string) bool {
+	switch content {
+	case "text/plain", "text/csv", "text/markdown", "text/x-markdown", "application/json":
+		return true
+	}
+	return false
+}
+
+// Transcodes a text document to UTF-8 with Unix line endings into processing/text-<id>/, recording the encoding it was in.
+// The transcoded copy becomes the document's source, leaving the file in the bundle as it was.
+// Files sniffed as binary are only taken for text when they turn out to be UTF-16 without a byte order mark.
+// Returns the document's type, which becomes text for such files.
+func normaliseText(doc *document, content string) (string, error) {
+	if !textual(content) && content != "application/octet-stream" {
+		return content, nil
+	}
+	data, err := ioutil.ReadFile(doc.Source)
+	if err != nil {
+		return content, err
+	}
+	encoding := detectEncoding(data)
+	if encoding == "" || !textual(content) && encoding != encodingUTF16LE && encoding != encodingUTF16BE {
+		return content, nil
+	}
+	if !textual(content) {
+		content = refineType("text/plain", doc.Path)
+	}
+	doc.Encoding = encoding
+	normalised := normaliseLineEndings(decodeText(data, encoding))
+	if !bytes.Equal(normalised, data) {
+		infoLog.Printf("%s transcoded from %s\n", doc.Path, encoding)
+		dir := fmt.Sprintf("processing/text-%d", doc.id)
+		if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+			return content, err
+		}
+		output := filepath.Join(dir, filepath.Base(doc.Source))
+		if err := writeFile(output, normalised); err != nil {
+			return content, err
+		}
+		doc.Source = output
+	}
+	return content, nil
+}
+
+// Detects a text encoding by byte order mark, the pattern of zero bytes UTF-16 leaves in Latin text and
+// whether the bytes are valid UTF-8, falling back to Windows-1252. Empty when the data looks binary.
+func detectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xef\xbb\xbf")):
+		return encodingUTF8
+	case bytes.HasPrefix(data, []byte("\xff\xfe")):
+		return encodingUTF16LE
+	case bytes.HasPrefix(data, []byte("\xfe\xff")):
+		return encodingUTF16BE
+	}
+	sample := data
+	if len(sample) > 4096 {
+		sample = sample[:4096]
+	}
+	if len(sample) >= 2 {
+		// Counts of zero high bytes and of the control characters they would make, for either byte order
+		even, odd, evenControls, oddControls := 0, 0, 0, 0
+		for i := 0; i+1 < len(sample); i += 2 {
+			if sample[i] == 0 {
+				even++
+				if control(sample[i+1]) {
+					evenControls++
+				}
+			}
+			if sample[i+1] == 0 {
+				odd++
+				if control(sample[i]) {
+					oddControls++
+				}
+			}
+		}
+		pairs := len(sample) / 2
+		if odd*10 >= pairs*4 && even*10 < pairs && oddControls == 0 {
+			return encodingUTF16LE
+		}
+		if even*10 >= pairs*4 && odd*10 < pairs && evenControls == 0 {
+			return encodingUTF16BE
+		}
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return ""
+	}
+	ascii := true
+	for _, b := range data {
+		if b >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return encodingASCII
+	}
+	if utf8.Valid(data) {
+		return encodingUTF8
+	}
+	return encoding1252
+}
+
+// Control characters other than whitespace, which text does not contain
+func control(b byte) bool {
+	return b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f'
+}
+
+// Decodes the data to UTF-8 without a byte order mark
+func decodeText(data []byte, encoding string) []byte {
+	switch encoding {
+	case encodingUTF16LE, encodingUTF16BE:
+		var order binary.ByteOrder = binary.LittleEndian
+		if encoding == encodingUTF16BE {
+			order = binary.BigEndian
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			units = append(units, order.Uint16(data[i:]))
+		}
+		if len(units) > 0 && units[0] == 0xFEFF {
+			units = units[1:]
+		}
+		return []byte(string(utf16.Decode(units)))
+	case encoding1252:
+		var text strings.Builder
+		for _, b := range data {
+			if b >= 0x80 && b < 0xA0 {
+				text.WriteRune(windows1252[b-0x80])
+			} else {
+				text.WriteRune(rune(b))
+			}
+		}
+		return []byte(text.String())
+	}
+	return bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+}
+
+// Turns Windows and classic Mac line endings into Unix ones
+func normaliseLineEndings(data []byte) []byte {
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(data, []byte("\r"), []byte("\n"), -1)
+}
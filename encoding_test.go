@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	for text, expected := range map[string]string{
+		"plain text\n":                       encodingASCII,
+		"caf\xc3\xa9":                        encodingUTF8,
+		"\xef\xbb\xbfbom":                    encodingUTF8,
+		"\xff\xfeh\x00i\x00":                 encodingUTF16LE,
+		"\xfe\xff\x00h\x00i":                 encodingUTF16BE,
+		"h\x00e\x00l\x00l\x00o\x00":          encodingUTF16LE,
+		"\x00h\x00e\x00l\x00l\x00o":          encodingUTF16BE,
+		"caf\xe9 \x93quoted\x94":             encoding1252,
+		"PK\x03\x04\x14\x00\x06\x00\x08\x00": "",
+	} {
+		if encoding := detectEncoding([]byte(text)); encoding != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, text, encoding)
+		}
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	for _, c := range []struct{ data, encoding, expected string }{
+		{"\xff\xfec\x00a\x00f\x00\xe9\x00", encodingUTF16LE, "café"},
+		{"\x00c\x00a\x00f\x00\xe9", encodingUTF16BE, "café"},
+		{"caf\xe9 \x93quoted\x94 \x80", encoding1252, "café “quoted” €"},
+		{"\xef\xbb\xbfcaf\xc3\xa9", encodingUTF8, "café"},
+	} {
+		if text := string(decodeText([]byte(c.data), c.encoding)); text != c.expected {
+			t.Errorf("Expected %q, got %q", c.expected, text)
+		}
+	}
+	if text := string(normaliseLineEndings([]byte("a\r\nb\rc\n"))); text != "a\nb\nc\n" {
+		t.Errorf("Incorrect line endings, got %q", text)
+	}
+}
+
+func TestNormaliseText(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+
+	utf16 := "n\x00a\x00m\x00e\x00\r\x00\n\x00"
+	source := "notes.csv"
+	ioutil.WriteFile(source, []byte(utf16), 0644)
+	doc := &document{id: 2, Path: "notes.csv", Source: source}
+	content, err := normaliseText(doc, "application/octet-stream")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if contents, _ := ioutil.ReadFile(doc.Source); string(contents) != "name\n" || content != "text/csv" || doc.Encoding != encodingUTF16LE {
+		t.Errorf("Expected UTF-16 CSV to be transcoded, got %q as %s from %s", contents, content, doc.Encoding)
+	}
+	if contents, _ := ioutil.ReadFile(source); doc.Source != "processing/text-2/notes.csv" || string(contents) != utf16 {
+		t.Errorf("Expected a transcoded copy beside the untouched original, got %s and %q", doc.Source, contents)
+	}
+
+	binary := []byte("PK\x03\x04\r\n\x00\x00\x93")
+	source = "book.xlsx"
+	ioutil.WriteFile(source, binary, 0644)
+	doc = &document{Path: "book.xlsx", Source: source}
+	if content, err := normaliseText(doc, "application/octet-stream"); err != nil || content != "application/octet-stream" || doc.Encoding != "" {
+		t.Errorf("Expected binary files to be left alone, got %s, %q and %v", content, doc.Encoding, err)
+	}
+	if contents, _ := ioutil.ReadFile(source); string(contents) != string(binary) {
+		t.Errorf("Expected binary files to be untouched, got %q", contents)
+	}
+}
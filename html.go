@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Paper sizes in millimetres, portrait
+var paperSizes = map[string][2]float64{
+	"A3":      {297, 420},
+	"A4":      {210, 297},
+	"A5":      {148, 210},
+	"Letter":  {215.9, 279.4},
+	"Legal":   {215.9, 355.6},
+	"Tabloid": {279.4, 431.8},
+}
+
+var lengthPattern = regexp.MustCompile(`^([0-9]*\.?[0-9]+)(mm|cm|in|pt)$`)
+
+var millimetresPer = map[string]float64{"mm": 1, "cm": 10, "in": 25.4, "pt": 25.4 / 72}
+
+// How HTML is rendered, set for the job and overridden by a document's manifest options of the same names
+type htmlOptions struct {
+	PageSize        string  `json:"page_size"`   // A3, A4, A5, Letter, Legal or Tabloid, the page's own CSS size by default
+	Orientation     string  `json:"orientation"` // portrait or landscape
+	MarginTop       string  `json:"margin_top"`  // Lengths in mm, cm, in or pt such as 10mm
+	MarginBottom    string  `json:"margin_bottom"`
+	MarginLeft      string  `json:"margin_left"`
+	MarginRight     string  `json:"margin_right"`
+	Media           string  `json:"media"`            // print or screen CSS
+	Background      *bool   `json:"background"`       // Print background colours and images, true by default
+	JavaScript      *bool   `json:"javascript"`       // Run the page's scripts, true by default
+	JavaScriptDelay int     `json:"javascript_delay"` // Milliseconds to wait for scripts after the page loads
+	Zoom            float64 `json:"zoom"`             // Scale of the content, 1 by default
+	Header          string  `json:"header"`           // HTML repeated at the top of each page
+	Footer          string  `json:"footer"`           // HTML repeated at the bottom of each page
+}
+
+// The job's options with the manifest's options for the document laid over them
+func (o htmlOptions) merge(options map[string]string) (htmlOptions, error) {
+	for key, value := range options {
+		var err error
+		switch key {
+		case "page_size":
+			o.PageSize = value
+		case "orientation":
+			o.Orientation = value
+		case "margin_top":
+			o.MarginTop = value
+		case "margin_bottom":
+			o.MarginBottom = value
+		case "margin_left":
+			o.MarginLeft = value
+		case "margin_right":
+			o.MarginRight = value
+		case "media":
+			o.Media = value
+		case "background":
+			o.Background, err = parseFlag(value)
+		case "javascript":
+			o.JavaScript, err = parseFlag(value)
+		case "javascript_delay":
+			o.JavaScriptDelay, err = strconv.Atoi(value)
+		case "zoom":
+			o.Zoom, err = strconv.ParseFloat(value, 64)
+		case "header":
+			o.Header = value
+		case "footer":
+			o.Footer = value
+		}
+		if err != nil {
+			return o, fmt.Errorf("Invalid %s option %q", key, value)
+		}
+	}
+	return o, o.validate()
+}
+
+func parseFlag(value string) (*bool, error) {
+	b, err := strconv.ParseBool(value)
+	return &b, err
+}
+
+func (o htmlOptions) validate() error {
+	if _, ok := paperSizes[o.PageSize]; o.PageSize != "" && !ok {
+		return fmt.Errorf("Unknown page size %q", o.PageSize)
+	}
+	if o.Orientation != "" && o.Orientation != "portrait" && o.Orientation != "landscape" {
+		return fmt.Errorf("Unknown orientation %q", o.Orientation)
+	}
+	for _, margin := range []string{o.MarginTop, o.MarginBottom, o.MarginLeft, o.MarginRight} {
+		if _, err := millimetres(margin); err != nil {
+			return err
+		}
+	}
+	if o.Media != "" && o.Media != "print" && o.Media != "screen" {
+		return fmt.Errorf("Unknown media %q", o.Media)
+	}
+	if o.JavaScriptDelay < 0 || o.JavaScriptDelay > 30000 {
+		return fmt.Errorf("JavaScript delay %dms is out of range", o.JavaScriptDelay)
+	}
+	if o.Zoom != 0 && (o.Zoom < 0.1 || o.Zoom > 2) {
+		return fmt.Errorf("Zoom %v is out of range", o.Zoom)
+	}
+	return nil
+}
+
+// Converts a length such as 1in to millimetres, an empty length is zero
+func millimetres(length string) (float64, error) {
+	if length == "" {
+		return 0, nil
+	}
+	match := lengthPattern.FindStringSubmatch(length)
+	if match == nil {
+		return 0, fmt.Errorf("Invalid length %q", length)
+	}
+	value, _ := strconv.ParseFloat(match[1], 64)
+	return value * millimetresPer[match[2]], nil
+}
+
+// wkhtmltopdf's arguments for the options, with the header and footer already written to files
+func (o htmlOptions) wkhtmltopdfArgs(header, footer string) []string {
+	args := []string{}
+	if o.PageSize != "" {
+		args = append(args, "--page-size", o.PageSize)
+	}
+	if o.Orientation != "" {
+		args = append(args, "--orientation", strings.Title(o.Orientation))
+	}
+	for i, margin := range []string{o.MarginTop, o.MarginBottom, o.MarginLeft, o.MarginRight} {
+		if mm, _ := millimetres(margin); margin != "" {
+			args = append(args, []string{"-T", "-B", "-L", "-R"}[i], strconv.FormatFloat(mm, 'f', -1, 64)+"mm")
+		}
+	}
+	switch o.Media {
+	case "print":
+		args = append(args, "--print-media-type")
+	case "screen":
+		args = append(args, "--no-print-media-type")
+	}
+	if o.Background != nil && !*o.Background {
+		args = append(args, "--no-background")
+	}
+	if o.JavaScript != nil && !*o.JavaScript {
+		args = append(args, "--disable-javascript")
+	}
+	if o.JavaScriptDelay > 0 {
+		args = append(args, "--javascript-delay", strconv.Itoa(o.JavaScriptDelay))
+	}
+	if o.Zoom != 0 {
+		args = append(args, "--zoom", strconv.FormatFloat(o.Zoom, 'f', -1, 64))
+	}
+	if header != "" {
+		args = append(args, "--header-html", header)
+	}
+	if footer != "" {
+		args = append(args, "--footer-html", footer)
+	}
+	return args
+}
+
+// Writes the header and footer for wkhtmltopdf, which fills the same placeholder classes as Chromium with a script
+func (o htmlOptions) writeHeaderFooter(doc *document) (string, string, error) {
+	files := []string{"", ""}
+	dir := fmt.Sprintf("processing/html-%d", doc.id)
+	for i, html := range []string{o.Header, o.Footer} {
+		if html == "" {
+			continue
+		}
+		if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+			return "", "", err
+		}
+		files[i] = filepath.Join(dir, []string{"header", "footer"}[i]+".html")
+		if err := writeFile(files[i], []byte(fmt.Sprintf(headerFooterHTML, html))); err != nil {
+			return "", "", err
+		}
+	}
+	return files[0], files[1], nil
+}
+
+// Page.printToPDF's parameters for the options
+func (o htmlOptions) printParams() map[string]interface{} {
+	params := map[string]interface{}{"printBackground": o.Background == nil || *o.Background, "preferCSSPageSize": o.PageSize == ""}
+	if size, ok := paperSizes[o.PageSize]; ok {
+		params["paperWidth"] = size[0] / 25.4
+		params["paperHeight"] = size[1] / 25.4
+	}
+	if o.Orientation == "landscape" {
+		params["landscape"] = true
+	}
+	for param, margin := range map[string]string{"marginTop": o.MarginTop, "marginBottom": o.MarginBottom, "marginLeft": o.MarginLeft, "marginRight": o.MarginRight} {
+		if mm, _ := millimetres(margin); margin != "" {
+			params[param] = mm / 25.4
+		}
+	}
+	if o.Zoom != 0 {
+		params["scale"] = o.Zoom
+	}
+	if o.Header != "" || o.Footer != "" {
+		// Chromium prints its own date and title where a template is missing
+		params["displayHeaderFooter"] = true
+		params["headerTemplate"] = "<span></span>"
+		params["footerTemplate"] = "<span></span>"
+		if o.Header != "" {
+			params["headerTemplate"] = o.Header
+		}
+		if o.Footer != "" {
+			params["footerTemplate"] = o.Footer
+		}
+	}
+	return params
+}
+
+// Wraps a header or footer for wkhtmltopdf, filling elements with Chromium's placeholder classes from the query string
+const headerFooterHTML = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"></head>
+<body style="margin: 0;">
+%s
+<script>
+(function() {
+	var vars = {};
+	var pairs = location.search.substring(1).split('&');
+	for (var i = 0; i < pairs.length; i++) {
+		var pair = pairs[i].split('=');
+		vars[pair[0]] = decodeURIComponent(pair[1] || '');
+	}
+	var names = {pageNumber: 'page', totalPages: 'topage', title: 'doctitle', date: 'date', url: 'webpage'};
+	for (var name in names) {
+		var elements = document.getElementsByClassName(name);
+		for (var j = 0; j < elements.length; j++) {
+			elements[j].textContent = vars[names[name]] || '';
+		}
+	}
+})();
+</script>
+</body>
+</html>
+`
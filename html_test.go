@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHTMLOptionsMerge(t *testing.T) {
+	job := htmlOptions{PageSize: "A4", MarginTop: "10mm", Zoom: 1.2}
+	o, err := job.merge(map[string]string{"orientation": "landscape", "margin_top": "1in", "background": "false", "javascript_delay": "500", "timeout": "10"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if o.PageSize != "A4" || o.Orientation != "landscape" || o.MarginTop != "1in" || o.Zoom != 1.2 || o.JavaScriptDelay != 500 {
+		t.Errorf("Incorrect options, got %+v", o)
+	}
+	if o.Background == nil || *o.Background {
+		t.Errorf("Expected the background to be off, got %v", o.Background)
+	}
+	if job.Orientation != "" {
+		t.Errorf("Expected the job's options to be left alone, got %+v", job)
+	}
+	for _, options := range []map[string]string{
+		{"page_size": "B5"},
+		{"orientation": "sideways"},
+		{"margin_left": "10furlongs"},
+		{"media": "tv"},
+		{"javascript": "maybe"},
+		{"zoom": "5"},
+	} {
+		if _, err := job.merge(options); err == nil {
+			t.Errorf("Expected an error for %v", options)
+		}
+	}
+}
+
+func TestWkhtmltopdfArgs(t *testing.T) {
+	off := false
+	o := htmlOptions{PageSize: "Letter", Orientation: "landscape", MarginTop: "1in", MarginLeft: "2cm", Media: "print", Background: &off, JavaScript: &off, JavaScriptDelay: 200, Zoom: 0.8}
+	expected := []string{"--page-size", "Letter", "--orientation", "Landscape", "-T", "25.4mm", "-L", "20mm", "--print-media-type",
+		"--no-background", "--disable-javascript", "--javascript-delay", "200", "--zoom", "0.8", "--footer-html", "footer.html"}
+	if args := o.wkhtmltopdfArgs("", "footer.html"); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+	if args := (htmlOptions{}).wkhtmltopdfArgs("", ""); len(args) != 0 {
+		t.Errorf("Expected no arguments by default, got %v", args)
+	}
+}
+
+func TestPrintParams(t *testing.T) {
+	params := htmlOptions{}.printParams()
+	if params["printBackground"] != true || params["preferCSSPageSize"] != true || len(params) != 2 {
+		t.Errorf("Incorrect default parameters, got %v", params)
+	}
+	params = htmlOptions{PageSize: "A4", Orientation: "landscape", MarginBottom: "1in", Footer: `<span class="pageNumber"></span>`}.printParams()
+	if params["preferCSSPageSize"] != false || params["landscape"] != true || params["marginBottom"] != 1.0 {
+		t.Errorf("Incorrect parameters, got %v", params)
+	}
+	if width := params["paperWidth"].(float64); width < 8.26 || width > 8.27 {
+		t.Errorf("Expected A4's width in inches, got %v", width)
+	}
+	if params["displayHeaderFooter"] != true || params["headerTemplate"] != "<span></span>" || !strings.Contains(params["footerTemplate"].(string), "pageNumber") {
+		t.Errorf("Incorrect header and footer, got %v", params)
+	}
+}
+
+func TestParseJobHTML(t *testing.T) {
+	j, err := parseJob(`{"key": "bundle", "html": {"page_size": "A4", "orientation": "portrait", "javascript": false}}`)
+	if err != nil || j.HTML.PageSize != "A4" || j.HTML.JavaScript == nil || *j.HTML.JavaScript {
+		t.Errorf("Incorrect HTML options, got %+v %v", j, err)
+	}
+	if _, err := parseJob(`{"key": "bundle", "html": {"page_size": "A0"}}`); err == nil {
+		t.Error("Expected an error for an unknown page size")
+	}
+}
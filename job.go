@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A job from the queue. The message is either the bare key of the tarball in the pending bucket
+// or a JSON object carrying the key along with options for the job.
+type job struct {
+	Key       string     `json:"key"`
+	Output    string     `json:"output"`    // How the converted documents are delivered, pdf, documents or zip
+	TOC       bool       `json:"toc"`       // Add a table of contents at the front of the output
+	Stamps    []stamp    `json:"stamps"`    // Text stamped on every page of the output
+	Bates     *bates     `json:"bates"`     // Number the output's pages
+	Watermark *watermark `json:"watermark"` // Text or an image laid over or under the output's pages
+
+	SummaryPosition string `json:"summary_position"` // Where the summary of files not processed goes, front or back
+	PDFA            string `json:"pdfa"`             // Produce PDF/A at the given level, 1b or 2b
+	Profile         string `json:"profile"`          // Optimisation profile, screen, ebook, printer, prepress or lossless
+	Linearize       bool   `json:"linearize"`        // Linearize the output for fast web view
+	MaxPages        int    `json:"max_pages"`        // Split the output into parts of at most this many pages
+	MaxBytes        int64  `json:"max_bytes"`        // Split the output into parts of at most this many bytes
+
+	Thumbnails *thumbnails `json:"thumbnails"` // Render previews of the output's pages
+	OCR        *ocr        `json:"ocr"`        // Add a text layer to pages without one
+	Text       string      `json:"text"`       // Deliver the text of each page, plain or json
+	Passwords  []string    `json:"passwords"`  // Tried on encrypted PDFs and Office documents
+
+	HTMLConverter string      `json:"html_converter"` // Converter for HTML in place of the -html-converter flag
+	HTML          htmlOptions `json:"html"`           // How HTML is rendered unless the manifest says otherwise
+
+	Spreadsheet  spreadsheetOptions  `json:"spreadsheet"`  // How spreadsheets are laid out unless the manifest says otherwise
+	Presentation presentationOptions `json:"presentation"` // How presentations are printed unless the manifest says otherwise
+}
+
+// Parses a job message, which is either a bare key or JSON. A job that fails validation is returned with its error so
+// it can still be reported against its key.
+func parseJob(body string) (*job, error) {
+	if !strings.HasPrefix(strings.TrimSpace(body), "{") {
+		return &job{Key: body}, nil
+	}
+	j := &job{}
+	if err := json.Unmarshal([]byte(body), j); err != nil {
+		if j.Key == "" {
+			return nil, fmt.Errorf("Could not parse job, err: %v", err.Error())
+		}
+		return j, fmt.Errorf("Could not parse job %q, err: %v", j.Key, err.Error())
+	}
+	if j.Key == "" {
+		return nil, fmt.Errorf("Job has no key")
+	}
+	if j.Output != "" && j.Output != outputStitched && j.Output != outputDocuments && j.Output != outputZip {
+		return j, fmt.Errorf("Job %q has an unknown output mode", j.Key)
+	}
+	if options := stitchingOptions(j); (j.Output == outputDocuments || j.Output == outputZip) && len(options) > 0 {
+		return j, fmt.Errorf("Job %q asks for %s, which only apply to pdf output", j.Key, strings.Join(options, ", "))
+	}
+	if j.HTMLConverter != "" && !knownHTMLConverter(j.HTMLConverter) {
+		return j, fmt.Errorf("Job %q names an unknown HTML converter", j.Key)
+	}
+	if err := j.HTML.validate(); err != nil {
+		return j, fmt.Errorf("Job %q has invalid HTML options, err: %v", j.Key, err.Error())
+	}
+	if err := j.Spreadsheet.validate(); err != nil {
+		return j, fmt.Errorf("Job %q has invalid spreadsheet options, err: %v", j.Key, err.Error())
+	}
+	if j.SummaryPosition != "" && j.SummaryPosition != "front" && j.SummaryPosition != "back" {
+		return j, fmt.Errorf("Job %q has an invalid summary position", j.Key)
+	}
+	if _, ok := pdfaParts[j.PDFA]; j.PDFA != "" && !ok {
+		return j, fmt.Errorf("Job %q has an invalid PDF/A level", j.Key)
+	}
+	if _, ok := profiles[j.Profile]; j.Profile != "" && !ok {
+		return j, fmt.Errorf("Job %q has an unknown optimisation profile", j.Key)
+	}
+	for _, s := range j.Stamps {
+		if err := s.validate(); err != nil {
+			return j, fmt.Errorf("Job %q has an invalid stamp, err: %v", j.Key, err.Error())
+		}
+	}
+	if j.Bates != nil {
+		if err := j.Bates.validate(); err != nil {
+			return j, fmt.Errorf("Job %q has invalid Bates numbering, err: %v", j.Key, err.Error())
+		}
+	}
+	if j.Watermark != nil {
+		if err := j.Watermark.validate(); err != nil {
+			return j, fmt.Errorf("Job %q has an invalid watermark, err: %v", j.Key, err.Error())
+		}
+	}
+	if j.Thumbnails != nil && !j.Thumbnails.valid() {
+		return j, fmt.Errorf("Job %q has invalid thumbnail options", j.Key)
+	}
+	if j.OCR != nil && !j.OCR.valid() {
+		return j, fmt.Errorf("Job %q has an invalid OCR language", j.Key)
+	}
+	if j.Text != "" && j.Text != textPlain && j.Text != textJSON {
+		return j, fmt.Errorf("Job %q has an unknown text format", j.Key)
+	}
+	if j.MaxPages < 0 || j.MaxBytes < 0 {
+		return j, fmt.Errorf("Job %q has a negative limit on the size of its output", j.Key)
+	}
+	return j, nil
+}
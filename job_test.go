@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseJobKey(t *testing.T) {
+	j, err := parseJob("bundle.tar.gz")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if j.Key != "bundle.tar.gz" || j.TOC {
+		t.Errorf("Incorrect job, got %+v", j)
+	}
+}
+
+func TestParseJobOptions(t *testing.T) {
+	j, err := parseJob(`{"key": "bundle.tar.gz", "toc": true}`)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if j.Key != "bundle.tar.gz" || !j.TOC {
+		t.Errorf("Incorrect job, got %+v", j)
+	}
+}
+
+func TestParseJobInvalid(t *testing.T) {
+	if _, err := parseJob(`{"key": `); err == nil {
+		t.Error("Expected an error for broken json")
+	}
+	if _, err := parseJob(`{"toc": true}`); err == nil {
+		t.Error("Expected an error for a missing key")
+	}
+	if _, err := parseJob(`{"key": "a.tar.gz", "watermark": {"text": " ", "opacity": 0.5}}`); err == nil {
+		t.Error("Expected an error for an empty watermark")
+	}
+	if _, err := parseJob(`{"key": "a.tar.gz", "watermark": {"text": "DRAFT", "opacity": 30}}`); err == nil {
+		t.Error("Expected an error for a watermark opacity above 1")
+	}
+	if _, err := parseJob(`{"key": "a.tar.gz", "watermark": {"text": "DRAFT", "color": "red"}}`); err == nil {
+		t.Error("Expected an error for an invalid watermark colour")
+	}
+	if _, err := parseJob(`{"key": "a.tar.gz", "stamps": [{"text": "{page}", "font": "Helvetica) show"}]}`); err == nil {
+		t.Error("Expected an error for an invalid stamp font")
+	}
+	if _, err := parseJob(`{"key": "a.tar.gz", "stamps": [{"text": "{page}", "position": "middle"}]}`); err == nil {
+		t.Error("Expected an error for an invalid stamp position")
+	}
+	if _, err := parseJob(`{"key": "a.tar.gz", "bates": {"digits": 40}}`); err == nil {
+		t.Error("Expected an error for too many Bates digits")
+	}
+	if _, err := parseJob(`{"key": "a.tar.gz", "stamps": [{"text": "{bates}", "position": "top-left"}], "bates": {"digits": 12}}`); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestParseJobErrorHidesPasswords(t *testing.T) {
+	_, err := parseJob(`{"key": "a.tar.gz", "passwords": ["hunter2"], "profile": "huge"}`)
+	if err == nil {
+		t.Fatal("Expected an error for an unknown profile")
+	}
+	if strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), "a.tar.gz") {
+		t.Errorf("Expected the error to name the key only, got %v", err)
+	}
+	if _, err := parseJob(`{"key": "a.tar.gz", "passwords": ["hunter2"`); err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Expected an error without the password, got %v", err)
+	}
+}
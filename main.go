@@ -8,19 +8,20 @@ import (
 	// Std library
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 	"bytes"
 
@@ -74,6 +75,15 @@ func (p *processingError) errorCode() string {
 func main() {
 	flag.Parse()
 
+	initTemplates()
+	initConverters()
+	if !knownHTMLConverter(*htmlConverter) {
+		fatalLog.Fatalf("Unknown HTML converter %q", *htmlConverter)
+	}
+	initEgress()
+	initSandbox()
+	initQpdf()
+	initLibreoffice()
 	initAWS()
 	closer := initTracing()
 	defer closer.Close()
@@ -131,8 +141,8 @@ func initPolling(quit chan struct{}) {
 	for {
 		select {
 		case <-ticker.C:
-			if filename := pollQueue(); filename != "" {
-				handleProcessingError(filename, processTar(filename))
+			if body := pollQueue(); body != "" {
+				handleJob(body)
 			}
 		case <-quit:
 			ticker.Stop()
@@ -141,6 +151,20 @@ func initPolling(quit chan struct{}) {
 	}
 }
 
+// Processes a job from the queue. A job that fails validation goes to the error bucket like one that fails to
+// process, and only a message without a key is dropped, as there is nothing to report it against.
+func handleJob(body string) {
+	j, err := parseJob(body)
+	if err != nil && j == nil {
+		errLog.Println(err.Error())
+		return
+	} else if err != nil {
+		handleProcessingError(j.Key, &processingError{err, 520})
+		return
+	}
+	handleProcessingError(j.Key, processTar(j))
+}
+
 // Handles any errors when interacting with SQS
 func handleQueueError(err error) string {
 	if err != nil {
@@ -226,10 +250,12 @@ func pollQueue() string {
 	return *messageResp.Messages[0].Body
 }
 
-func processTar(filename string) *processingError {
+func processTar(j *job) *processingError {
 	// Start trace
 	processSp := opentracing.StartSpan("Process task")
 	defer processSp.Finish()
+	filename := j.Key
+	result := newJobResult(j)
 
 	// Make the directory for converting files
 	err := os.MkdirAll("processing", os.FileMode(0755))
@@ -245,6 +271,7 @@ func processTar(filename string) *processingError {
 	defer os.RemoveAll("processed")
 
 	// Stream the file from s3
+	stage := time.Now()
 	params := &s3.GetObjectInput{
 		Bucket: &awsPendingBucket,
 		Key:    &filename,
@@ -256,55 +283,213 @@ func processTar(filename string) *processingError {
 		return &processingError{fmt.Errorf("Could not find %v, err: %v", filename, err.Error()), 404}
 	}
 	defer resp.Body.Close()
-	files, perr := decompress(resp.Body, processSp)
+	docs, perr := decompress(resp.Body, processSp)
 	if perr != nil {
 		return perr
 	}
+	result.timed("download", stage)
+	docs, perr = loadManifest(docs)
+	if perr != nil {
+		return perr
+	}
+	docs, watermarkImage := watermarkAsset(j.Watermark, docs)
+	markAssets(docs)
 
 	// The actual conversions
-	perr = convertFiles(files, processSp)
+	stage = time.Now()
+	perr = convertFiles(j, docs, processSp)
 	if perr != nil {
 		return perr
 	}
+	result.timed("convert", stage)
+	stage = time.Now()
+	preflightFiles(docs, processSp)
+	result.timed("preflight", stage)
+	if j.OCR != nil {
+		stage = time.Now()
+		ocrFiles(docs, j.OCR, processSp)
+		result.timed("ocr", stage)
+	}
+	if j.Output == outputDocuments || j.Output == outputZip {
+		return deliverDocuments(j, docs, result, processSp)
+	}
 
-	// The concatenation
-	processedContents, _ := ioutil.ReadDir("./processed")
-	files = []string{}
-	for _, f := range processedContents {
-		files = append(files, "processed/"+f.Name())
+	// PDF/A forbids encryption
+	encryption := []string{"-sOwnerPassword=reallylongandsecurepassword"}
+	if j.PDFA != "" {
+		encryption = []string{}
 	}
-	stitchSp := opentracing.StartSpan("Stitching", opentracing.ChildOf(processSp.Context()))
+	settings := append(append([]string{}, profiles[j.Profile]...), encryption...)
 
-	cmd := exec.Command("gs", append([]string{"-dBATCH", "-dPrinted=false", "-dNOPAUSE", "-dPDFFitPage", "-sOwnerPassword=reallylongandsecurepassword", "-sDEVICE=pdfwrite", "-sOutputFile=processed/" + filename + ".pdf"}, files...)...)
-	err = run(cmd)
+	// Lay out and stitch the output, leaving out the documents that stop the stitch until it succeeds
+	output := "processed/" + filename + ".pdf"
+	var files []string
+	var marks []bookmark
+	var outline string
+	for {
+		stage = time.Now()
+		outlineSp := opentracing.StartSpan("Outlining", opentracing.ChildOf(processSp.Context()))
+		files, marks, outline = layOut(j, docs)
+		outlineSp.Finish()
+		result.timed("layout", stage)
+
+		stage = time.Now()
+		stitchSp := opentracing.StartSpan("Stitching", opentracing.ChildOf(processSp.Context()))
+		result.SizeBefore = totalSize(files)
+		err = stitch(files, output, settings)
+		damaged := 0
+		if err != nil {
+			damaged = findDamaged(stitched(docs), func(probe []*document) error {
+				return stitch(outputs(probe), probeFile, settings)
+			})
+		}
+		stitchSp.Finish()
+		if err == nil {
+			break
+		}
+		if damaged == 0 {
+			return &processingError{fmt.Errorf("Could not concatenate to output PDF, err: %v", err.Error()), 550}
+		}
+		infoLog.Printf("Left out %d documents that could not be stitched\n", damaged)
+	}
+	result.timed("stitch", stage)
+
+	// Watermark the pages
+	if j.Watermark != nil {
+		stage = time.Now()
+		watermarkSp := opentracing.StartSpan("Watermarking", opentracing.ChildOf(processSp.Context()))
+		err = watermarkPages(output, j.Watermark, watermarkImage, docs)
+		watermarkSp.Finish()
+		if err != nil {
+			return &processingError{fmt.Errorf("Could not watermark the output PDF, err: %v", err.Error()), 571}
+		}
+		result.timed("watermark", stage)
+	}
 
-	if err != nil {
-	    cmd := exec.Command("gs", append([]string{"-dCompatibilityLevel=1.3", "-dBATCH", "-dPrinted=false", "-dNOPAUSE", "-dPDFFitPage", "-sOwnerPassword=reallylongandsecurepassword", "-sDEVICE=pdfwrite", "-sOutputFile=processed/" + filename + ".pdf"}, files...)...)
-        err = run(cmd)
+	// Stamp the pages
+	if len(j.Stamps) > 0 || j.Bates != nil {
+		stage = time.Now()
+		stampSp := opentracing.StartSpan("Stamping", opentracing.ChildOf(processSp.Context()))
+		err = stampPages(output, j, marks)
+		stampSp.Finish()
+		if err != nil {
+			return &processingError{fmt.Errorf("Could not stamp the output PDF, err: %v", err.Error()), 570}
+		}
+		result.timed("stamp", stage)
 	}
 
-	stitchSp.Finish()
+	// Archive the output
+	if j.PDFA != "" {
+		stage = time.Now()
+		archiveSp := opentracing.StartSpan("Archiving", opentracing.ChildOf(processSp.Context()))
+		err = archive(output, j.PDFA, j.Key, outline, profiles[j.Profile])
+		if err == nil {
+			result.PDFA = checkPDFA(output, j.PDFA)
+		}
+		archiveSp.Finish()
+		if err != nil {
+			return &processingError{fmt.Errorf("Could not convert the output to PDF/A, err: %v", err.Error()), 572}
+		}
+		result.timed("archive", stage)
+	}
 
-	if err != nil {
-		time.Sleep(1 * time.Minute)
-		return &processingError{fmt.Errorf("Could not concatenate to output PDF, err: %v", err.Error()), 550}
+	// Linearize for fast web view
+	if j.Linearize {
+		stage = time.Now()
+		linearizeSp := opentracing.StartSpan("Linearizing", opentracing.ChildOf(processSp.Context()))
+		err = linearize(output)
+		linearizeSp.Finish()
+		if err != nil {
+			return &processingError{fmt.Errorf("Could not linearize the output PDF, err: %v", err.Error()), 573}
+		}
+		result.timed("linearize", stage)
+	}
+
+	result.SizeAfter = totalSize([]string{output})
+	if result.Pages, err = pageCount(output); err != nil {
+		errLog.Printf("Could not count the pages of the output, err: %v", err)
+	}
+
+	// Split the output when it is too large for a single file
+	parts := []part{}
+	if j.MaxPages > 0 || j.MaxBytes > 0 {
+		stage = time.Now()
+		splitSp := opentracing.StartSpan("Splitting", opentracing.ChildOf(processSp.Context()))
+		parts, err = splitOutput(output, filename, j, marks, result.Pages)
+		splitSp.Finish()
+		if err != nil {
+			return &processingError{fmt.Errorf("Could not split the output PDF, err: %v", err.Error()), 574}
+		}
+		result.timed("split", stage)
 	}
 
 	// Upload the finished PDF to s3
-	in, err := os.Open("processed/" + filename + ".pdf")
+	stage = time.Now()
+	if len(parts) == 0 {
+		if perr := uploadFile(output, filename+".pdf", "application/pdf", processSp); perr != nil {
+			return perr
+		}
+	} else {
+		result.Output = ""
+		result.Parts = parts
+		for _, p := range parts {
+			if perr := uploadFile("processed/"+p.Key, p.Key, "application/pdf", processSp); perr != nil {
+				return perr
+			}
+		}
+	}
+	result.timed("upload", stage)
+
+	// Extract the text of the documents
+	if j.Text != "" {
+		stage = time.Now()
+		textSp := opentracing.StartSpan("Extracting Text", opentracing.ChildOf(processSp.Context()))
+		file, key, contentType, err := writeText(j.Text, filename, documentTexts(docs))
+		if err != nil {
+			textSp.Finish()
+			return &processingError{fmt.Errorf("Could not write the extracted text, err: %v", err.Error()), 577}
+		}
+		perr = uploadFile(file, key, contentType, textSp)
+		textSp.Finish()
+		if perr != nil {
+			return perr
+		}
+		result.Text = key
+		result.timed("text", stage)
+	}
+
+	// Preview the output, a job is not failed for want of previews
+	if j.Thumbnails != nil {
+		stage = time.Now()
+		thumbnailSp := opentracing.StartSpan("Thumbnails", opentracing.ChildOf(processSp.Context()))
+		images, err := renderThumbnails(output, j.Thumbnails)
+		if err != nil {
+			errLog.Printf("Could not render thumbnails, err: %v", err)
+		} else if result.Thumbnails, perr = uploadThumbnails(filename, images, thumbnailSp); perr != nil {
+			thumbnailSp.Finish()
+			return perr
+		}
+		thumbnailSp.Finish()
+		result.timed("thumbnails", stage)
+	}
+	result.finish(j, docs)
+	return uploadResult(result, processSp)
+}
+
+func uploadFile(file, key, contentType string, parentSp opentracing.Span) *processingError {
+	in, err := os.Open(file)
 	if err != nil {
 		return &processingError{fmt.Errorf("Could not find result, err: %v", err.Error()), 560}
 	}
 	defer in.Close()
 
-	pdf := "application/pdf"
 	putParams := &s3.PutObjectInput{
 		Bucket:      &awsDoneBucket,
-		Key:         aws.String(filename + ".pdf"),
+		Key:         aws.String(key),
 		Body:        in,
-		ContentType: &pdf,
+		ContentType: aws.String(contentType),
 	}
-	putSp := opentracing.StartSpan("PutObject", opentracing.ChildOf(processSp.Context()))
+	putSp := opentracing.StartSpan("PutObject", opentracing.ChildOf(parentSp.Context()))
 	_, err = s3session.PutObject(putParams)
 	putSp.Finish()
 	if err != nil {
@@ -313,7 +498,7 @@ func processTar(filename string) *processingError {
 	return nil
 }
 
-func decompress(in io.Reader, parentSp opentracing.Span) ([]string, *processingError) {
+func decompress(in io.Reader, parentSp opentracing.Span) ([]*document, *processingError) {
 	// Decompress the file
 	decompressSp := opentracing.StartSpan("Decompressing Files", opentracing.ChildOf(parentSp.Context()))
 	defer decompressSp.Finish()
@@ -323,7 +508,7 @@ func decompress(in io.Reader, parentSp opentracing.Span) ([]string, *processingE
 		return nil, &processingError{fmt.Errorf("Could not decompress file, err: %v", err.Error()), 530}
 	}
 	tarReader := tar.NewReader(gzf)
-	files := []string{}
+	docs := []*document{}
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
@@ -338,133 +523,225 @@ func decompress(in io.Reader, parentSp opentracing.Span) ([]string, *processingE
 		case tar.TypeDir:
 			// Left blank on purpose
 		case tar.TypeReg:
-			_, file := filepath.Split(header.Name)
-			name := "processing/" + file
-			writer, err := os.Create(name)
+			// The bundle keeps its directories so relative references between its files still resolve
+			p := bundlePath(header.Name)
+			if p == "" {
+				continue
+			}
+			name := filepath.Join(bundleDir, filepath.FromSlash(p))
+			err = os.MkdirAll(filepath.Dir(name), os.FileMode(0755))
+			if err != nil {
+				return nil, &processingError{fmt.Errorf("Could not decompress file, got error %v", err.Error()), 533}
+			}
+			writer, err := createFile(name)
 			if err != nil {
 				return nil, &processingError{fmt.Errorf("Could not decompress file, got error %v", err.Error()), 533}
 			}
 
-			io.Copy(writer, tarReader)
+			hash := sha256.New()
+			io.Copy(io.MultiWriter(writer, hash), tarReader)
 
-			err = os.Chmod(name, os.FileMode(header.Mode))
+			// Every tool may read the file, and only the execute bits are kept from the archive so a root
+			// owned file cannot be setuid
+			err = os.Chmod(name, os.FileMode(0644)|os.FileMode(header.Mode)&os.FileMode(0111))
 			writer.Close()
 			if err != nil {
 				return nil, &processingError{fmt.Errorf("Could not change permissions got error %v", err.Error()), 534}
 			}
 
-			files = append(files, name)
+			docs = append(docs, &document{Path: p, Source: name, Size: header.Size, SHA256: hex.EncodeToString(hash.Sum(nil))})
 		default:
 			return nil, &processingError{fmt.Errorf("Unknown file type %v", header.Typeflag), 531}
 		}
 	}
-	return files, nil
+	return docs, nil
 }
 
-func convertFiles(files []string, parentSp opentracing.Span) *processingError {
+// Lays out the output, returning the files to stitch, the top of the outline and the pdfmark file when
+// one was written. The outline is only written when every page could be counted.
+func layOut(j *job, docs []*document) ([]string, []bookmark, string) {
+	complete := countPages(docs)
+	front := []*section{}
+	back := []*section{}
+	summary, err := renderSummary(j, docs)
+	if err != nil {
+		errLog.Printf("Could not render the summary, err: %v", err)
+		complete = complete && j.SummaryPosition != "front"
+	} else if summary != nil && j.SummaryPosition == "front" {
+		front = append(front, summary)
+	} else if summary != nil {
+		back = append(back, summary)
+	}
+	if j.TOC && complete {
+		following := 0
+		for _, s := range front {
+			following += s.PageCount
+		}
+		contents, err := renderContents(docs, following)
+		if err != nil {
+			errLog.Printf("Could not render the table of contents, err: %v", err)
+		} else {
+			front = append([]*section{contents}, front...)
+		}
+	}
+	files, marks, links := assemble(front, docs, back)
+	outline := ""
+	if complete && len(marks) > 0 {
+		// The pdfmarks follow the documents so their page numbers refer to the stitched PDF
+		if err = writeOutline(outlineFile, marks, links); err == nil {
+			outline = outlineFile
+			files = append(files, outline)
+		} else {
+			errLog.Printf("Could not write the outline, err: %v", err)
+		}
+	}
+	return files, marks, outline
+}
+
+func convertFiles(j *job, docs []*document, parentSp opentracing.Span) *processingError {
 	convertSp := opentracing.StartSpan("Converting Files", opentracing.ChildOf(parentSp.Context()))
 	defer convertSp.Finish()
-	notDone := []string{}
-	for _, file := range files {
+	for _, doc := range docs {
+		if doc.excluded() || doc.Status == statusEmbedded {
+			continue
+		}
+		start := time.Now()
+		perr := convertDocument(j, doc, convertSp)
+		doc.Duration = time.Since(start)
+		if perr != nil {
+			return perr
+		}
+	}
+	orphanAssets(docs)
+	return nil
+}
 
-		infoLog.Printf(" File being processed: - %s\n", file)
+func convertDocument(j *job, doc *document, convertSp opentracing.Span) *processingError {
+	file := doc.Source
 
-		content, err := getFileType(file)
+	infoLog.Printf(" File being processed: - %s\n", file)
 
-		if err != nil {
-			errLog.Printf("conversion error was: %s", err)
-		}
-		switch content {
-		case "application/pdf":
-			_, filename := filepath.Split(file)
-			err = os.Link(file, "processed/"+filename)
-			if err != nil {
-				notDone = append(notDone, filename)
-			}
-		case "text/html", "text/htm":
-			in, err := os.Open(file)
-			if err != nil {
-				return &processingError{fmt.Errorf("Could not find file, err: %v", err), 540}
-			}
-			_, filename := filepath.Split(file)
-			out, err := os.Create("processed/" + filename)
-			if err != nil {
-				notDone = append(notDone, filename)
-				continue
-			}
-			cmd := exec.Command("wkhtmltopdf", "--quiet", "-", "-")
-			cmd.Stdin = in
-			cmd.Stdout = out
-			err = run(cmd)
-			in.Close()
-			out.Close()
-			if err != nil {
-				notDone = append(notDone, filename)
-			}
-		default:
-			_, filename := filepath.Split(file)
-			documentStripSp := opentracing.StartSpan("Dos2Unix converting", opentracing.ChildOf(convertSp.Context()))
-			command := exec.Command("dos2unix", "--quiet", filename)
-			err := run(command)
-			documentStripSp.Finish()
-			if err != nil {
-				return &processingError{fmt.Errorf("Could not strip files got error %v", err.Error()), 543}
-			}
-			documentConvertSp := opentracing.StartSpan("Libreoffice converting", opentracing.ChildOf(convertSp.Context()))
-			notDone = libre(filename, notDone)
-			documentConvertSp.Finish()
+	content, err := getFileType(file)
+
+	if err != nil {
+		errLog.Printf("conversion error was: %s", err)
+	}
+	content = refineType(content, doc.Path)
+	if override, ok := doc.Options["type"]; ok {
+		content = override
+	}
+	doc.Type = content
+	decrypted, err := decrypt(doc, content, j.Passwords)
+	if err == errEncrypted {
+		detail := "no password supplied"
+		if len(j.Passwords) > 0 {
+			detail = "none of the passwords opened it"
 		}
+		doc.exclude(reasonEncrypted, detail)
+		return nil
+	} else if err == errEncryptedArchive {
+		doc.exclude(reasonEncrypted, err.Error())
+		return nil
+	} else if err != nil {
+		doc.fail(err)
+		return nil
+	} else if decrypted && content != "application/pdf" {
+		content, _ = getFileType(doc.Source)
+		content = refineType(content, doc.Path)
+	}
+	if content, err = normaliseText(doc, content); err != nil {
+		doc.fail(err)
+		return nil
 	}
+	doc.Type = content
 
-	if len(notDone) > 0 {
-		for i := range notDone {
-			infoLog.Printf("%s summarized\n", notDone[i])
-			notDone[i] = fmt.Sprintf("<tr><td>%s</td></tr>", notDone[i])
+	preferred := *htmlConverter
+	if j.HTMLConverter != "" {
+		preferred = j.HTMLConverter
+	}
+	c := converterFor(content, preferred)
+	if _, fallback := c.(libreofficeConverter); c == nil || fallback && unsupportedType(content) {
+		doc.exclude(reasonUnsupported, content)
+		return nil
+	}
+	// The HTML and office options only matter to the converters that read them, and are no reason to fail anything else
+	switch c.(type) {
+	case wkhtmltopdfConverter, chromiumConverter, renderedConverter:
+		if doc.HTML, err = j.HTML.merge(doc.Options); err != nil {
+			doc.fail(err)
+			return nil
 		}
-		summary, _ := os.Create("processing/summary.html")
-		_, _ = summary.WriteString(style)
-		_, _ = summary.WriteString(fmt.Sprintf(table, strings.Join(notDone, "")))
-		summary.Close()
-		in, _ := os.Open("processing/summary.html")
-		out, _ := os.Create("processed/summary.pdf")
-		cmd := exec.Command("wkhtmltopdf", "--quiet", "-", "-")
-		cmd.Stdin = in
-		cmd.Stdout = out
-		_ = run(cmd)
-		in.Close()
-		out.Close()
+	case calcConverter:
+		if doc.Spreadsheet, err = j.Spreadsheet.merge(doc.Options); err != nil {
+			doc.fail(err)
+			return nil
+		}
+	case impressConverter:
+		if doc.Presentation, err = j.Presentation.merge(doc.Options); err != nil {
+			doc.fail(err)
+			return nil
+		}
+	}
+	doc.htmlConverter = preferred
+	doc.Converter = c.Name()
+	output := fmt.Sprintf("processed/%d.pdf", doc.id)
+	err = c.Convert(doc, output, convertSp)
+	if perr, ok := err.(*processingError); ok {
+		return perr
+	} else if err != nil {
+		doc.fail(err)
+	} else {
+		doc.converted(output)
+	}
+
+	if doc.Output != "" && doc.Pages != "" {
+		selectPages(doc)
 	}
 	return nil
 }
 
-func libre(filename string, notDone []string) []string {
-	cmd := exec.Command("lowriter", "--invisible", "--convert-to", "pdf:writer_pdf_Export:UTF8", "--outdir", "processing", "processing/"+filename)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Start()
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-	select {
-	case <-time.After(3 * time.Second):
-		infoLog.Printf("%s not printed\n", filename)
-		pgid, _ := syscall.Getpgid(cmd.Process.Pid)
-		if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
-			log.Fatal("failed to kill: ", err)
-		}
-		<-done
-		notDone = append(notDone, filename)
-	case err := <-done:
-		if err != nil {
-			infoLog.Printf("%s is error %s\n", filename, err)
-			notDone = append(notDone, filename)
-		}
-		err = os.Link("processing/"+filename+".pdf", "processed/"+filename+".pdf")
-		if err != nil {
-			notDone = append(notDone, filename)
+func libre(doc *document, output string) error {
+	_, filename := filepath.Split(doc.Source)
+	timeout := documentTimeout(doc, 3*time.Second)
+	// Each document gets its own output directory as LibreOffice names the result after the input's stem
+	outdir, err := newScratch()
+	if err != nil {
+		return err
+	}
+	defer outdir.remove()
+	args := []string{"--invisible", "--convert-to", "pdf:writer_pdf_Export:UTF8", "--outdir", string(outdir), doc.Source}
+	if libreofficeProfile != "" {
+		args = append([]string{"-env:UserInstallation=" + (&url.URL{Scheme: "file", Path: libreofficeProfile}).String()}, args...)
+	}
+	cmd := exec.Command("lowriter", args...)
+	if err := runWithTimeout(cmd, timeout, false); err != nil {
+		infoLog.Printf("%s not printed, err: %v\n", filename, err)
+		return err
+	}
+	return outdir.collect(strings.TrimSuffix(filename, filepath.Ext(filename))+".pdf", output)
+}
+
+// Types LibreOffice has no hope of turning into a document
+func unsupportedType(content string) bool {
+	for _, prefix := range []string{"audio/", "video/", "font/", "application/x-gzip", "application/x-rar-compressed", "application/wasm"} {
+		if strings.HasPrefix(content, prefix) {
+			return true
 		}
 	}
-	return notDone
+	return false
+}
+
+// Cuts the converted document down to the pages the manifest asked for
+func selectPages(doc *document) {
+	output := fmt.Sprintf("processed/%d-pages.pdf", doc.id)
+	err := fromScratch(output, func(selected string) error {
+		return run(exec.Command("gs", "-dBATCH", "-dNOPAUSE", "-dQUIET", "-sDEVICE=pdfwrite", "-sPageList="+doc.Pages, "-sOutputFile="+selected, doc.Output))
+	})
+	if err != nil {
+		doc.fail(err)
+		return
+	}
+	doc.converted(output)
 }
 
 func getFileType(filename string) (string, error) {
@@ -474,10 +751,13 @@ func getFileType(filename string) (string, error) {
 	}
 	defer file.Close()
 
-	// Only the first 512 bytes are used to sniff the content type.
+	// Only the first 512 bytes are used to sniff the content type, and only those read so short files are not padded
+	// out with zeros that make them look binary. An empty file is empty text.
 	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
-	if err != nil {
+	n, err := file.Read(buffer)
+	if err == io.EOF {
+		return "text/plain", nil
+	} else if err != nil {
 		return "", err
 	}
 
@@ -485,7 +765,7 @@ func getFileType(filename string) (string, error) {
 	file.Seek(0, 0)
 
 	// Always returns a valid content-type and "application/octet-stream" if no others seemed to match.
-	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(buffer))
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(buffer[:n]))
 	return mediaType, nil
 }
 
@@ -496,7 +776,12 @@ func run(cmd *exec.Cmd) error {
         cmd.Stdout = &stdout
     }
     cmd.Stderr = &stderr
-    err := cmd.Run()
+    cleanup, err := sandbox(cmd, false)
+    if err != nil {
+        return err
+    }
+    defer cleanup()
+    err = cmd.Run()
     if err != nil {
         errLog.Println(cmd.Path, cmd.Args)
         errLog.Println(err.Error())
@@ -506,100 +791,7 @@ func run(cmd *exec.Cmd) error {
         if (stderr.Len() > 0) {
             errLog.Println("Error stream", stderr.String())
         }
+        return &toolError{err, stderr.String(), false, resourceLimit(cmd.ProcessState)}
     }
-    return err
+    return nil
 }
-
-const table = "<div class=\"repzone\">" +
-	"<table cellspacing=1 cellpadding=2>" +
-	"<tr>" +
-	"<td align='left' style=\"font-weight:bold;\" class='s0med'>Files Not Processed</td>" +
-	"</tr>" +
-	"%s" +
-	"</table>" +
-	"</div>" +
-	"</div>"
-
-const style = "<div id=\"repprintarea\">" +
-	"<style type=\"text/css\">" +
-	"h2 {" +
-	"font-family: Verdana, Arial, Helvetica, \"PT Sans\", sans-serif;" +
-	"font-size: 15pt;" +
-	"}" +
-	".medheading {" +
-	"font-family: Verdana, Arial, Helvetica, \"PT Sans\", sans-serif;" +
-	"font-size: 12pt;" +
-	"font-weight: bold;" +
-	"}" +
-	".repzone table {" +
-	"border: 2px solid black;" +
-	"border-collapse:collapse;" +
-	"}" +
-	".repzone td {" +
-	"border: 1px solid black;" +
-	"font-family: Verdana, Arial, Helvetica, \"PT Sans\", sans-serif;" +
-	"font-size: 8pt;" +
-	"vertical-align: top;" +
-	"}" +
-	".repzone.error {" +
-	"color:red;" +
-	"font-weight:bold;" +
-	"}" +
-	".repzone.noterror {" +
-	"color:green;" +
-	"font-weight:bold;" +
-	"}" +
-	".bargraph table {" +
-	"border: none;" +
-	"}" +
-	".bargraph td {" +
-	"border: none;" +
-	"font-family: Verdana, Arial, Helvetica, \"PT Sans\", sans-serif;" +
-	"font-size: 8pt;" +
-	"vertical-align: top;" +
-	"}" +
-	".printtabtlabel {" +
-	"white-space: nowrap;" +
-	"font-weight: bold;" +
-	"}" +
-	".printtabllabel {" +
-	"white-space: nowrap;" +
-	"text-align: right;" +
-	"}" +
-	".printtabhighdata {" +
-	"background-color: #cccccc;" +
-	"}" +
-	".printtabgreendata {" +
-	"background-color: #ccffcc; " +
-	"}" +
-	".s0lt {" +
-	"background-color: #FFF5E6;" +
-	"}" +
-	".s0med {" +
-	"background-color: #FFE6BF;" +
-	"}" +
-	".s0full {" +
-	"background-color: #FFCC80;" +
-	"}" +
-	".s0dark {" +
-	"background-color: #BF9960;" +
-	"}" +
-	".repzone {" +
-	"font-family:Verdana, Arial, Helvetica, \"PT Sans\", sans-serif;" +
-	"font-size:8pt;" +
-	"}" +
-	".repzone DIV {" +
-	"font-family:Verdana, Arial, Helvetica, \"PT Sans\", sans-serif;" +
-	"font-size:8pt;" +
-	"}" +
-	".repzone p {" +
-	"margin:0;" +
-	"padding:0;" +
-	"font-family:Verdana, Arial, Helvetica, \"PT Sans\", sans-serif;" +
-	"font-size:8pt;" +
-	"}" +
-	".repzone A {" +
-	"font-family:Verdana, Arial, Helvetica, \"PT Sans\", sans-serif;" +
-	"font-size:8pt;" +
-	"}" +
-	"</style>"
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -82,6 +83,25 @@ func TestHandleProcessingErr(t *testing.T) {
 	}
 }
 
+func TestHandleJobInvalid(t *testing.T) {
+	s3Struct := stubS3{}
+	s3session = &s3Struct
+	handleJob(`{"key": "bundle.tar.gz", "profile": "huge", "passwords": ["secret"]}`)
+	if s3Struct.copyReceived == nil || *s3Struct.copyReceived.Key != "bundle.tar.gz" {
+		t.Fatalf("Expected the invalid job to go to the error bucket, got %+v", s3Struct.copyReceived)
+	}
+	if *s3Struct.copyReceived.Metadata["Response"] != "520" || strings.Contains(*s3Struct.copyReceived.Metadata["Error"], "secret") {
+		t.Errorf("Incorrect error, got %v", s3Struct.copyReceived.Metadata)
+	}
+
+	s3Struct = stubS3{}
+	s3session = &s3Struct
+	handleJob(`{"toc": true}`)
+	if s3Struct.copyReceived != nil {
+		t.Errorf("Expected a job without a key to be dropped, got %+v", s3Struct.copyReceived)
+	}
+}
+
 func TestQueueNotFound(t *testing.T) {
 	expected := errors.New("TEST")
 	sqsStruct := stubSQS{
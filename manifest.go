@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Name of the optional manifest at the root of the tarball
+const manifestName = "manifest.json"
+
+// Where the tarball is extracted
+const bundleDir = "processing/bundle"
+
+// Page selections are handed to Ghostscript's PageList, e.g. "1-3,5,9-"
+var pageRangePattern = regexp.MustCompile(`^\d+(-\d*)?(,\d+(-\d*)?)*$`)
+
+type manifest struct {
+	Documents []manifestEntry `json:"documents"`
+}
+
+type manifestEntry struct {
+	Path    string            `json:"path"`
+	Title   string            `json:"title"`
+	Pages   string            `json:"pages"`
+	Skip    bool              `json:"skip"`
+	Options map[string]string `json:"options"`
+}
+
+// Cleans a tar header name so it can be compared against manifest paths
+func bundlePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Removes the manifest from the documents and orders what remains, falling back to a natural sort without one
+func loadManifest(docs []*document) ([]*document, *processingError) {
+	var m *manifest
+	rest := []*document{}
+	for _, doc := range docs {
+		if doc.Path != manifestName {
+			rest = append(rest, doc)
+			continue
+		}
+		contents, err := ioutil.ReadFile(doc.Source)
+		if err != nil {
+			return nil, &processingError{fmt.Errorf("Could not read the manifest, err: %v", err.Error()), 535}
+		}
+		m = &manifest{}
+		if err = json.Unmarshal(contents, m); err != nil {
+			return nil, &processingError{fmt.Errorf("Could not parse the manifest, err: %v", err.Error()), 536}
+		}
+	}
+	ordered, err := orderDocuments(rest, m)
+	if err != nil {
+		return nil, &processingError{err, 536}
+	}
+	for i, doc := range ordered {
+		doc.id = i
+		if doc.Title == "" {
+			doc.Title = doc.Path
+		}
+	}
+	return ordered, nil
+}
+
+// Applies the manifest to the documents, anything it does not mention follows in natural order
+func orderDocuments(docs []*document, m *manifest) ([]*document, error) {
+	remaining := map[string]*document{}
+	for _, doc := range docs {
+		remaining[doc.Path] = doc
+	}
+
+	ordered := []*document{}
+	if m != nil {
+		listed := map[string]bool{}
+		for _, entry := range m.Documents {
+			p := bundlePath(entry.Path)
+			if entry.Pages != "" && !pageRangePattern.MatchString(entry.Pages) {
+				return nil, fmt.Errorf("Invalid page range %q for %v", entry.Pages, p)
+			}
+			if listed[p] {
+				return nil, fmt.Errorf("%v is listed more than once in the manifest", p)
+			}
+			listed[p] = true
+			doc, ok := remaining[p]
+			if !ok {
+				infoLog.Printf("%s is in the manifest but not the bundle\n", p)
+				doc = &document{Path: p}
+				doc.exclude(reasonMissing, "listed in the manifest but not in the bundle")
+			}
+			delete(remaining, p)
+			if entry.Skip {
+				infoLog.Printf("%s skipped by the manifest\n", p)
+				doc.exclude(reasonRule, "skipped by the manifest")
+			}
+			doc.Title = entry.Title
+			doc.Pages = entry.Pages
+			doc.Options = entry.Options
+			ordered = append(ordered, doc)
+		}
+	}
+
+	unlisted := []*document{}
+	for _, doc := range docs {
+		if _, ok := remaining[doc.Path]; ok {
+			unlisted = append(unlisted, doc)
+		}
+	}
+	sort.SliceStable(unlisted, func(i, j int) bool {
+		return naturalLess(unlisted[i].Path, unlisted[j].Path)
+	})
+	return append(ordered, unlisted...), nil
+}
+
+// Compares strings treating runs of digits as numbers, so "2.docx" sorts before "10.pdf"
+func naturalLess(a, b string) bool {
+	ar, br := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ar) && j < len(br) {
+		if unicode.IsDigit(ar[i]) && unicode.IsDigit(br[j]) {
+			si, sj := i, j
+			for i < len(ar) && unicode.IsDigit(ar[i]) {
+				i++
+			}
+			for j < len(br) && unicode.IsDigit(br[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ar[si:i]), "0")
+			nb := strings.TrimLeft(string(br[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		ca, cb := unicode.ToLower(ar[i]), unicode.ToLower(br[j])
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+	if len(ar)-i != len(br)-j {
+		return len(ar)-i < len(br)-j
+	}
+	return a < b
+}
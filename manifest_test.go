@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func paths(docs []*document) []string {
+	result := []string{}
+	for _, doc := range docs {
+		result = append(result, doc.Path)
+	}
+	return result
+}
+
+func equalPaths(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNaturalLess(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"2.docx", "10.pdf", true},
+		{"10.pdf", "2.docx", false},
+		{"exhibit 9", "Exhibit 10", true},
+		{"a02", "a2", true},
+		{"a2", "a02", false},
+		{"a", "a1", true},
+		{"b", "a1", false},
+	}
+	for _, c := range cases {
+		if naturalLess(c.a, c.b) != c.expected {
+			t.Errorf("naturalLess(%q, %q) expected %v", c.a, c.b, c.expected)
+		}
+	}
+}
+
+func TestBundlePath(t *testing.T) {
+	for name, expected := range map[string]string{
+		"./manifest.json": "manifest.json",
+		"/a/b.pdf":        "a/b.pdf",
+		"a/../b/./c.docx": "b/c.docx",
+		"exhibits/1.pdf":  "exhibits/1.pdf",
+	} {
+		if bundlePath(name) != expected {
+			t.Errorf("bundlePath(%q) expected %v, got %v", name, expected, bundlePath(name))
+		}
+	}
+}
+
+func TestOrderDocumentsWithoutManifest(t *testing.T) {
+	docs := []*document{{Path: "10.pdf"}, {Path: "2.docx"}, {Path: "1.html"}}
+	ordered, err := orderDocuments(docs, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if expected := []string{"1.html", "2.docx", "10.pdf"}; !equalPaths(paths(ordered), expected) {
+		t.Errorf("Expected %v, got %v", expected, paths(ordered))
+	}
+}
+
+func TestOrderDocumentsWithManifest(t *testing.T) {
+	docs := []*document{{Path: "a.pdf"}, {Path: "b.pdf"}, {Path: "c.pdf"}, {Path: "d.pdf"}}
+	m := &manifest{Documents: []manifestEntry{
+		{Path: "c.pdf", Title: "Exhibit A", Pages: "1-2"},
+		{Path: "./a.pdf", Skip: true},
+		{Path: "missing.pdf"},
+		{Path: "b.pdf", Options: map[string]string{"timeout": "10"}},
+	}}
+	ordered, err := orderDocuments(docs, m)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if expected := []string{"c.pdf", "a.pdf", "missing.pdf", "b.pdf", "d.pdf"}; !equalPaths(paths(ordered), expected) {
+		t.Errorf("Expected %v, got %v", expected, paths(ordered))
+	}
+	if ordered[0].Title != "Exhibit A" || ordered[0].Pages != "1-2" {
+		t.Errorf("Manifest entry not applied, got %+v", ordered[0])
+	}
+	if ordered[1].Status != statusSkipped || ordered[1].Reason != reasonRule {
+		t.Errorf("Document should be skipped by the manifest, got %+v", ordered[1])
+	}
+	if ordered[2].Source != "" || ordered[2].Reason != reasonMissing {
+		t.Errorf("Document should be missing, got %+v", ordered[2])
+	}
+	if ordered[3].Options["timeout"] != "10" || ordered[3].excluded() {
+		t.Errorf("Options not applied, got %+v", ordered[3])
+	}
+}
+
+func TestOrderDocumentsInvalidPages(t *testing.T) {
+	docs := []*document{{Path: "a.pdf"}}
+	m := &manifest{Documents: []manifestEntry{{Path: "a.pdf", Pages: "1-2; rm"}}}
+	if _, err := orderDocuments(docs, m); err == nil {
+		t.Error("Expected an error for an invalid page range")
+	}
+}
+
+func TestOrderDocumentsDuplicate(t *testing.T) {
+	docs := []*document{{Path: "a.pdf"}, {Path: "b.pdf"}}
+	m := &manifest{Documents: []manifestEntry{{Path: "a.pdf"}, {Path: "b.pdf"}, {Path: "./a.pdf", Title: "Again"}}}
+	if _, err := orderDocuments(docs, m); err == nil || !strings.Contains(err.Error(), "a.pdf is listed more than once") {
+		t.Errorf("Expected an error for a path listed twice, got %v", err)
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, manifestName)
+	ioutil.WriteFile(source, []byte(`{"documents": [{"path": "b.pdf", "title": "First"}]}`), 0644)
+
+	docs := []*document{{Path: "a.pdf"}, {Path: manifestName, Source: source}, {Path: "b.pdf"}}
+	ordered, perr := loadManifest(docs)
+	if perr != nil {
+		t.Fatalf("Unexpected error %v", perr)
+	}
+	if expected := []string{"b.pdf", "a.pdf"}; !equalPaths(paths(ordered), expected) {
+		t.Errorf("Expected %v, got %v", expected, paths(ordered))
+	}
+	if ordered[0].Title != "First" || ordered[1].Title != "a.pdf" {
+		t.Errorf("Titles not set, got %v and %v", ordered[0].Title, ordered[1].Title)
+	}
+	if ordered[0].id != 0 || ordered[1].id != 1 {
+		t.Errorf("Ids not assigned, got %v and %v", ordered[0].id, ordered[1].id)
+	}
+
+	ioutil.WriteFile(source, []byte(`{`), 0644)
+	if _, perr = loadManifest(docs); perr == nil || perr.code != 536 {
+		t.Errorf("Expected a 536 for a broken manifest, got %v", perr)
+	}
+}
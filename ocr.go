@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// Resolution pages are rendered at for recognition
+const ocrDPI = 300
+
+var languagePattern = regexp.MustCompile(`^[A-Za-z_]+$`)
+
+// Recognition of text on pages that have none, such as scans
+type ocr struct {
+	Languages []string `json:"languages"` // Tesseract language codes such as eng or chi_sim, eng by default
+}
+
+func (o *ocr) valid() bool {
+	for _, language := range o.Languages {
+		if !languagePattern.MatchString(language) {
+			return false
+		}
+	}
+	return true
+}
+
+// Adds a text layer to the pages of each converted document that lack one, a document that cannot be read keeps its pages as they are
+func ocrFiles(docs []*document, o *ocr, parentSp opentracing.Span) {
+	ocrSp := opentracing.StartSpan("OCR", opentracing.ChildOf(parentSp.Context()))
+	defer ocrSp.Finish()
+	for _, doc := range docs {
+		if doc.Output == "" {
+			continue
+		}
+		if err := ocrDocument(doc, o); err != nil {
+			errLog.Printf("Could not OCR %s, err: %v", doc.Path, err)
+		}
+	}
+}
+
+// Renders each page without text, has tesseract recognise it as an invisible text-only PDF and lays that over the page
+func ocrDocument(doc *document, o *ocr) error {
+	d, err := readQpdfDocument(doc.Output)
+	if err != nil {
+		return err
+	}
+	pages := d.textlessPages()
+	if len(pages) == 0 {
+		return nil
+	}
+	languages := "eng"
+	if len(o.Languages) > 0 {
+		languages = strings.Join(o.Languages, "+")
+	}
+	dir := fmt.Sprintf("processing/ocr-%d", doc.id)
+	if err = os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return err
+	}
+	scratch, err := newScratch()
+	if err != nil {
+		return err
+	}
+	defer scratch.remove()
+	layers := []layer{}
+	confidences := []float64{}
+	for _, page := range pages {
+		base := scratch.path(strconv.Itoa(page))
+		cmd := exec.Command("gs", "-dBATCH", "-dNOPAUSE", "-dQUIET", "-sDEVICE=pnggray", fmt.Sprintf("-r%d", ocrDPI),
+			fmt.Sprintf("-dFirstPage=%d", page), fmt.Sprintf("-dLastPage=%d", page), "-sOutputFile="+base+".png", doc.Output)
+		if err = run(cmd); err != nil {
+			return err
+		}
+		cmd = exec.Command("tesseract", base+".png", base, "-l", languages, "--dpi", strconv.Itoa(ocrDPI), "-c", "textonly_pdf=1", "pdf", "tsv")
+		if err = run(cmd); err != nil {
+			return err
+		}
+		tsv, err := scratch.read(fmt.Sprintf("%d.tsv", page))
+		if err != nil {
+			return err
+		}
+		confidences = append(confidences, wordConfidences(string(tsv))...)
+		text := fmt.Sprintf("%s/%d.pdf", dir, page)
+		if err = scratch.collect(fmt.Sprintf("%d.pdf", page), text); err != nil {
+			return err
+		}
+		layers = append(layers, layer{File: text, To: strconv.Itoa(page)})
+	}
+	if err = overlay(doc.Output, false, layers...); err != nil {
+		return err
+	}
+	doc.OCRPages = len(pages)
+	doc.OCRConfidence = 0
+	for _, c := range confidences {
+		doc.OCRConfidence += c / float64(len(confidences))
+	}
+	infoLog.Printf("%s OCRed %d pages with %.1f%% confidence\n", doc.Path, doc.OCRPages, doc.OCRConfidence)
+	return nil
+}
+
+// Reads the confidence of each word from tesseract's tsv output, where other levels of the layout have a confidence of -1
+func wordConfidences(tsv string) []float64 {
+	confidences := []float64{}
+	for i, line := range strings.Split(tsv, "\n") {
+		fields := strings.Split(line, "\t")
+		if i == 0 || len(fields) < 12 || fields[0] != "5" || strings.TrimSpace(fields[11]) == "" {
+			continue
+		}
+		if c, err := strconv.ParseFloat(fields[10], 64); err == nil && c >= 0 {
+			confidences = append(confidences, c)
+		}
+	}
+	return confidences
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWordConfidences(t *testing.T) {
+	tsv := "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n" +
+		"1\t1\t0\t0\t0\t0\t0\t0\t2480\t3508\t-1\t\n" +
+		"4\t1\t1\t1\t1\t0\t300\t300\t800\t40\t-1\t\n" +
+		"5\t1\t1\t1\t1\t1\t300\t300\t200\t40\t96.5\tInvoice\n" +
+		"5\t1\t1\t1\t1\t2\t520\t300\t100\t40\t81\tNo.\n" +
+		"5\t1\t1\t1\t1\t3\t640\t300\t10\t40\t95\t \n"
+	expected := []float64{96.5, 81}
+	if confidences := wordConfidences(tsv); !reflect.DeepEqual(confidences, expected) {
+		t.Errorf("Expected %v, got %v", expected, confidences)
+	}
+}
+
+func TestParseJobOCR(t *testing.T) {
+	j, err := parseJob(`{"key": "bundle", "ocr": {"languages": ["eng", "chi_sim"]}}`)
+	if err != nil || j.OCR == nil || len(j.OCR.Languages) != 2 {
+		t.Errorf("Expected two OCR languages, got %+v %v", j, err)
+	}
+	if _, err := parseJob(`{"key": "bundle", "ocr": {"languages": ["eng; rm -rf"]}}`); err == nil {
+		t.Error("Expected an error for an invalid language")
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// LibreOffice's user profile, kept for the worker's lifetime as creating one takes seconds of each conversion's timeout
+var libreofficeProfile string
+
+// Creates the profile for the sandbox user, stopping the worker from starting when it cannot
+func initLibreoffice() {
+	var err error
+	if libreofficeProfile, err = ioutil.TempDir("", "libreoffice-"); err != nil {
+		fatalLog.Fatalf("Cannot create the LibreOffice profile: %v", err)
+	}
+	if sandboxCredential != nil {
+		if err = os.Chown(libreofficeProfile, int(sandboxCredential.Uid), int(sandboxCredential.Gid)); err != nil {
+			fatalLog.Fatalf("Cannot hand the LibreOffice profile to the sandbox user: %v", err)
+		}
+	}
+}
+
+var officeScript = flag.String("office-script", "/usr/local/lib/frisket/office.py", "Script exporting spreadsheets and presentations through LibreOffice's UNO API")
+
+var spreadsheetTypes = []string{
+	"application/vnd.ms-excel",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"application/vnd.ms-excel.sheet.macroEnabled.12",
+	"application/vnd.oasis.opendocument.spreadsheet",
+}
+
+var presentationTypes = []string{
+	"application/vnd.ms-powerpoint",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"application/vnd.oasis.opendocument.presentation",
+}
+
+// How spreadsheets are laid out, set for the job and overridden by a document's manifest options of the same names
+type spreadsheetOptions struct {
+	FitWidth     bool  `json:"fit_width"`     // Scale each sheet to the width of the page
+	Landscape    bool  `json:"landscape"`     // Turn every sheet's pages to landscape
+	HiddenSheets bool  `json:"hidden_sheets"` // Include hidden sheets, which are skipped by default
+	PrintAreas   *bool `json:"print_areas"`   // Print only the print areas of sheets that define them, true by default
+	MaxSheets    int   `json:"max_sheets"`    // Print at most this many sheets, all by default
+}
+
+// How presentations are printed, set for the job and overridden by the manifest
+type presentationOptions struct {
+	Notes bool `json:"notes"` // Follow the slides with their speaker notes
+}
+
+// The job's options with the manifest's options for the document laid over them
+func (o spreadsheetOptions) merge(options map[string]string) (spreadsheetOptions, error) {
+	for key, value := range options {
+		var err error
+		switch key {
+		case "fit_width":
+			o.FitWidth, err = strconv.ParseBool(value)
+		case "landscape":
+			o.Landscape, err = strconv.ParseBool(value)
+		case "hidden_sheets":
+			o.HiddenSheets, err = strconv.ParseBool(value)
+		case "print_areas":
+			o.PrintAreas, err = parseFlag(value)
+		case "max_sheets":
+			o.MaxSheets, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return o, fmt.Errorf("Invalid %s option %q", key, value)
+		}
+	}
+	return o, o.validate()
+}
+
+func (o spreadsheetOptions) validate() error {
+	if o.MaxSheets < 0 {
+		return fmt.Errorf("Invalid sheet limit %d", o.MaxSheets)
+	}
+	return nil
+}
+
+// The office script's arguments for the options
+func (o spreadsheetOptions) args() []string {
+	args := []string{}
+	if o.FitWidth {
+		args = append(args, "--fit-width")
+	}
+	if o.Landscape {
+		args = append(args, "--landscape")
+	}
+	if o.HiddenSheets {
+		args = append(args, "--hidden-sheets")
+	}
+	if o.PrintAreas != nil && !*o.PrintAreas {
+		args = append(args, "--ignore-print-areas")
+	}
+	if o.MaxSheets > 0 {
+		args = append(args, "--max-sheets", strconv.Itoa(o.MaxSheets))
+	}
+	return args
+}
+
+func (o presentationOptions) merge(options map[string]string) (presentationOptions, error) {
+	if value, ok := options["notes"]; ok {
+		notes, err := strconv.ParseBool(value)
+		if err != nil {
+			return o, fmt.Errorf("Invalid notes option %q", value)
+		}
+		o.Notes = notes
+	}
+	return o, nil
+}
+
+// Spreadsheets go through Calc's PDF export rather than Writer's, which cuts wide sheets into columns of pages
+type calcConverter struct{}
+
+func (calcConverter) Name() string    { return "calc" }
+func (calcConverter) Types() []string { return spreadsheetTypes }
+func (calcConverter) Priority() int   { return 0 }
+
+func (calcConverter) Convert(doc *document, output string, sp opentracing.Span) error {
+	calcSp := opentracing.StartSpan("Calc exporting", opentracing.ChildOf(sp.Context()))
+	defer calcSp.Finish()
+	return exportOffice(doc, output, "calc_pdf_Export", doc.Spreadsheet.args())
+}
+
+type impressConverter struct{}
+
+func (impressConverter) Name() string    { return "impress" }
+func (impressConverter) Types() []string { return presentationTypes }
+func (impressConverter) Priority() int   { return 0 }
+
+func (impressConverter) Convert(doc *document, output string, sp opentracing.Span) error {
+	impressSp := opentracing.StartSpan("Impress exporting", opentracing.ChildOf(sp.Context()))
+	defer impressSp.Finish()
+	args := []string{}
+	if doc.Presentation.Notes {
+		args = append(args, "--notes")
+	}
+	return exportOffice(doc, output, "impress_pdf_Export", args)
+}
+
+// Runs the office script, which starts its own LibreOffice and so goes with it on a timeout
+func exportOffice(doc *document, output, filter string, args []string) error {
+	return fromScratch(output, func(exported string) error {
+		args = append([]string{*officeScript, "--filter", filter, "--input", doc.Source, "--output", exported}, args...)
+		cmd := exec.Command("python3", args...)
+		return runWithTimeout(cmd, documentTimeout(doc, 60*time.Second), false)
+	})
+}
@@ -0,0 +1,125 @@
+#!/usr/bin/env python3
+"""Exports a spreadsheet or presentation to PDF through LibreOffice's UNO API.
+
+Starts its own headless LibreOffice with a private profile, applies the page setup
+asked for and stores the document with Calc's or Impress's PDF export filter.
+"""
+
+import argparse
+import os
+import shutil
+import subprocess
+import sys
+import tempfile
+import time
+
+import uno
+from com.sun.star.beans import PropertyValue
+
+
+def properties(**values):
+    return tuple(PropertyValue(Name=name, Value=value) for name, value in values.items())
+
+
+def connect(pipe, process):
+    local = uno.getComponentContext()
+    resolver = local.ServiceManager.createInstanceWithContext("com.sun.star.bridge.UnoUrlResolver", local)
+    for _ in range(300):
+        if process.poll() is not None:
+            raise RuntimeError("LibreOffice exited with %d" % process.returncode)
+        try:
+            return resolver.resolve("uno:pipe,name=%s;urp;StarOffice.ComponentContext" % pipe)
+        except Exception:
+            time.sleep(0.1)
+    raise RuntimeError("Could not connect to LibreOffice")
+
+
+def lay_out_sheets(document, args):
+    styles = document.StyleFamilies.getByName("PageStyles")
+    printed = 0
+    for sheet in (document.Sheets.getByIndex(i) for i in range(document.Sheets.Count)):
+        if args.hidden_sheets:
+            sheet.IsVisible = True
+        if not sheet.IsVisible:
+            continue
+        printed += 1
+        # Sheets past the limit are hidden, which the export leaves out
+        if args.max_sheets and printed > args.max_sheets:
+            sheet.IsVisible = False
+            continue
+        if args.ignore_print_areas:
+            sheet.setPrintAreas(())
+        style = styles.getByName(sheet.PageStyle)
+        if args.landscape and not style.IsLandscape:
+            width, height = style.Width, style.Height
+            style.IsLandscape = True
+            style.Width, style.Height = max(width, height), min(width, height)
+        if args.fit_width:
+            style.ScaleToPagesX = 1
+            style.ScaleToPagesY = 0
+
+
+def export(desktop, args):
+    document = desktop.loadComponentFromURL(
+        uno.systemPathToFileUrl(os.path.abspath(args.input)), "_blank", 0, properties(Hidden=True))
+    if document is None:
+        raise RuntimeError("LibreOffice could not open %s" % args.input)
+    try:
+        if args.filter == "calc_pdf_Export":
+            lay_out_sheets(document, args)
+        filter_data = properties(ExportNotesPages=args.notes) if args.filter == "impress_pdf_Export" else ()
+        store = (
+            PropertyValue(Name="FilterName", Value=args.filter),
+            PropertyValue(Name="FilterData", Value=uno.Any("[]com.sun.star.beans.PropertyValue", filter_data)),
+        )
+        uno.invoke(document, "storeToURL", (uno.systemPathToFileUrl(os.path.abspath(args.output)), store))
+    finally:
+        document.close(True)
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__)
+    parser.add_argument("--input", required=True)
+    parser.add_argument("--output", required=True)
+    parser.add_argument("--filter", required=True, choices=["calc_pdf_Export", "impress_pdf_Export"])
+    parser.add_argument("--fit-width", action="store_true")
+    parser.add_argument("--landscape", action="store_true")
+    parser.add_argument("--hidden-sheets", action="store_true")
+    parser.add_argument("--ignore-print-areas", action="store_true")
+    parser.add_argument("--max-sheets", type=int, default=0)
+    parser.add_argument("--notes", action="store_true")
+    args = parser.parse_args()
+
+    profile = tempfile.mkdtemp(prefix="office-")
+    pipe = "frisket-%d" % os.getpid()
+    process = subprocess.Popen([
+        "soffice", "--headless", "--invisible", "--nologo", "--norestore", "--nodefault",
+        "-env:UserInstallation=" + uno.systemPathToFileUrl(profile),
+        "--accept=pipe,name=%s;urp;" % pipe,
+    ])
+    try:
+        context = connect(pipe, process)
+        desktop = context.ServiceManager.createInstanceWithContext("com.sun.star.frame.Desktop", context)
+        try:
+            export(desktop, args)
+        finally:
+            try:
+                desktop.terminate()
+            except Exception:
+                pass
+        try:
+            process.wait(timeout=30)
+        except subprocess.TimeoutExpired:
+            pass
+    except Exception as e:
+        print(e, file=sys.stderr)
+        return 1
+    finally:
+        if process.poll() is None:
+            process.kill()
+        shutil.rmtree(profile, ignore_errors=True)
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+func TestSpreadsheetOptions(t *testing.T) {
+	off := false
+	job := spreadsheetOptions{FitWidth: true, PrintAreas: &off}
+	o, err := job.merge(map[string]string{"landscape": "true", "max_sheets": "3", "print_areas": "true", "page_size": "A4"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []string{"--fit-width", "--landscape", "--max-sheets", "3"}
+	if args := o.args(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+	expected = []string{"--fit-width", "--ignore-print-areas"}
+	if args := job.args(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected the job's options to be left alone, got %v", args)
+	}
+	for _, options := range []map[string]string{{"max_sheets": "-1"}, {"fit_width": "wide"}, {"hidden_sheets": "some"}} {
+		if _, err := job.merge(options); err == nil {
+			t.Errorf("Expected an error for %v", options)
+		}
+	}
+}
+
+func TestPresentationOptions(t *testing.T) {
+	o, err := presentationOptions{}.merge(map[string]string{"notes": "true"})
+	if err != nil || !o.Notes {
+		t.Errorf("Expected notes, got %+v and %v", o, err)
+	}
+	if _, err := o.merge(map[string]string{"notes": "please"}); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
+
+func TestOfficeConverters(t *testing.T) {
+	for content, expected := range map[string]string{
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         "calc",
+		"application/vnd.oasis.opendocument.spreadsheet":                            "calc",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation": "impress",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   "libreoffice",
+	} {
+		if c := converterFor(content, ""); c.Name() != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, content, c.Name())
+		}
+	}
+}
+
+// Stands in for lowriter, recording its arguments and writing the PDF to the --outdir it is given
+const fakeLowriter = `#!/bin/sh
+echo "$@" > lowriter.args
+while [ $# -gt 1 ]; do
+	[ "$1" = --outdir ] && outdir=$2
+	shift
+done
+echo "%PDF-1.4" > "$outdir/$(basename "${1%.*}").pdf"
+`
+
+func TestLibreProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	os.MkdirAll("processing", 0755)
+	os.MkdirAll("bin", 0755)
+	ioutil.WriteFile("bin/lowriter", []byte(fakeLowriter), 0755)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", filepath.Join(dir, "bin")+":"+os.Getenv("PATH"))
+	ioutil.WriteFile("letter.docx", []byte("PK"), 0644)
+	defer func(p string) { libreofficeProfile = p }(libreofficeProfile)
+	libreofficeProfile = "/tmp/libreoffice-1"
+
+	if err := libre(&document{Source: "letter.docx"}, "letter.pdf"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if args, _ := ioutil.ReadFile("lowriter.args"); !strings.HasPrefix(string(args), "-env:UserInstallation=file:///tmp/libreoffice-1 ") {
+		t.Errorf("Expected the shared profile, got %q", args)
+	}
+	if contents, _ := ioutil.ReadFile("letter.pdf"); string(contents) != "%PDF-1.4\n" {
+		t.Errorf("Expected the converted document, got %q", contents)
+	}
+}
+
+func TestOfficeIgnoresHTMLOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	for _, d := range []string{"processing", "processed", "bin"} {
+		os.MkdirAll(d, 0755)
+	}
+	ioutil.WriteFile("bin/lowriter", []byte(fakeLowriter), 0755)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", filepath.Join(dir, "bin")+":"+os.Getenv("PATH"))
+	ioutil.WriteFile("letter.rtf", []byte(`{\rtf1 Dear Sir}`), 0644)
+
+	doc := &document{id: 1, Path: "letter.rtf", Source: "letter.rtf", Options: map[string]string{"page_size": "Foolscap"}}
+	if perr := convertDocument(&job{}, doc, opentracing.StartSpan("test")); perr != nil {
+		t.Fatalf("Unexpected error %v", perr)
+	}
+	if doc.Status != statusConverted {
+		t.Errorf("Expected the HTML options to be ignored, got %+v", doc)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Ghostscript settings for each optimisation profile, controlling image downsampling, JPEG quality and font subsetting.
+// Without a profile Ghostscript's defaults apply.
+var profiles = map[string][]string{
+	// 72 dpi images at low JPEG quality, for reading on screen
+	"screen": {"-dPDFSETTINGS=/screen"},
+	// 150 dpi images at medium JPEG quality
+	"ebook": {"-dPDFSETTINGS=/ebook"},
+	// 300 dpi images at high JPEG quality
+	"printer": {"-dPDFSETTINGS=/printer"},
+	// 300 dpi images at maximum JPEG quality, preserving colour
+	"prepress": {"-dPDFSETTINGS=/prepress"},
+	// Images are neither downsampled nor recompressed and fonts are embedded whole
+	"lossless": {
+		"-dDownsampleColorImages=false", "-dDownsampleGrayImages=false", "-dDownsampleMonoImages=false",
+		"-dAutoFilterColorImages=false", "-dAutoFilterGrayImages=false", "-dColorImageFilter=/FlateEncode",
+		"-dGrayImageFilter=/FlateEncode", "-dPassThroughJPEGImages=true", "-dSubsetFonts=false",
+	},
+}
+
+// Rewrites the file for fast web view, keeping any encryption
+func linearize(file string) error {
+	return fromScratch(file, func(linearized string) error {
+		return run(exec.Command("qpdf", "--linearize", file, linearized))
+	})
+}
+
+// Total size of the files, skipping any that cannot be read
+func totalSize(files []string) int64 {
+	var size int64
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTotalSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.pdf"), filepath.Join(dir, "b.pdf")
+	ioutil.WriteFile(a, make([]byte, 10), 0644)
+	ioutil.WriteFile(b, make([]byte, 32), 0644)
+	if size := totalSize([]string{a, b, filepath.Join(dir, "missing.pdf")}); size != 42 {
+		t.Errorf("Expected 42 bytes, got %d", size)
+	}
+}
+
+func TestParseJobProfile(t *testing.T) {
+	j, err := parseJob(`{"key": "bundle", "profile": "ebook", "linearize": true}`)
+	if err != nil || j.Profile != "ebook" || !j.Linearize {
+		t.Errorf("Expected the ebook profile linearized, got %+v %v", j, err)
+	}
+	if _, err := parseJob(`{"key": "bundle", "profile": "tiny"}`); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"unicode/utf16"
+)
+
+// Where the pdfmark program describing the outline is written before stitching
+const outlineFile = "processing/outline.ps"
+
+// Titles of the bookmarks pointing at sections that are not documents
+const summaryTitle = "Files Not Processed"
+const contentsTitle = "Contents"
+
+// An entry in the outline of the stitched PDF
+type bookmark struct {
+	Title string
+	Page  int
+	Kids  []bookmark
+}
+
+// The subset of qpdf's json output describing a document's outline
+type qpdfOutline struct {
+	Title string        `json:"title"`
+	Page  int           `json:"destpageposfrom1"`
+	Kids  []qpdfOutline `json:"kids"`
+}
+
+// Pages stitched alongside the documents, such as the summary
+type section struct {
+	File      string
+	Title     string
+	PageCount int
+	Links     []link // Annotations linking from the section to elsewhere in the stitched PDF
+}
+
+// A clickable area on a page of a section, pointing at a page of the stitched PDF
+type link struct {
+	SourcePage int // Page of the section the area is on
+	Rect       [4]float64
+	Page       int
+}
+
+func newSection(file, title string) (*section, error) {
+	count, err := pageCount(file)
+	if err != nil {
+		return nil, err
+	}
+	return &section{File: file, Title: title, PageCount: count}, nil
+}
+
+// Counts the pages of each converted document, returning false if any could not be counted
+func countPages(docs []*document) bool {
+	complete := true
+	for _, doc := range docs {
+		if doc.Output == "" {
+			continue
+		}
+		count, err := pageCount(doc.Output)
+		if err != nil {
+			errLog.Printf("Could not count the pages of %s, err: %v", doc.Path, err)
+			complete = false
+			continue
+		}
+		doc.PageCount = count
+	}
+	return complete
+}
+
+// Places the converted documents one after another from the given page, returning the page following them
+func layoutPages(docs []*document, page int) int {
+	for _, doc := range docs {
+		if doc.Output == "" {
+			doc.FirstPage = 0
+			continue
+		}
+		doc.FirstPage = page
+		page += doc.PageCount
+	}
+	return page
+}
+
+// Lays out the sections and documents in order, returning the files to stitch along with a bookmark for each of them
+func assemble(front []*section, docs []*document, back []*section) ([]string, []bookmark, []link) {
+	files := []string{}
+	marks := []bookmark{}
+	links := []link{}
+	page := 1
+	addSection := func(s *section) {
+		files = append(files, s.File)
+		marks = append(marks, bookmark{Title: s.Title, Page: page})
+		for _, l := range s.Links {
+			l.SourcePage += page - 1
+			links = append(links, l)
+		}
+		page += s.PageCount
+	}
+
+	for _, s := range front {
+		addSection(s)
+	}
+	page = layoutPages(docs, page)
+	for _, doc := range docs {
+		if doc.Output == "" {
+			continue
+		}
+		files = append(files, doc.Output)
+		kids, err := sourceOutline(doc.Output)
+		if err != nil {
+			errLog.Printf("Could not read the outline of %s, err: %v", doc.Path, err)
+		}
+		marks = append(marks, bookmark{Title: doc.Title, Page: doc.FirstPage, Kids: offsetOutline(kids, doc.FirstPage-1, doc.PageCount)})
+	}
+	for _, s := range back {
+		addSection(s)
+	}
+	return files, marks, links
+}
+
+func sourceOutline(file string) ([]qpdfOutline, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("qpdf", "--json", "--json-key=outlines", file)
+	cmd.Stdout = &out
+	if err := run(cmd); err != nil {
+		return nil, err
+	}
+	return parseOutline(out.Bytes())
+}
+
+func parseOutline(contents []byte) ([]qpdfOutline, error) {
+	parsed := struct {
+		Outlines []qpdfOutline `json:"outlines"`
+	}{}
+	if err := json.Unmarshal(contents, &parsed); err != nil {
+		return nil, err
+	}
+	return parsed.Outlines, nil
+}
+
+// Moves a document's own bookmarks to where the document lands, dropping any that point outside it
+func offsetOutline(outlines []qpdfOutline, offset, count int) []bookmark {
+	marks := []bookmark{}
+	for _, o := range outlines {
+		if o.Page < 1 || o.Page > count {
+			continue
+		}
+		marks = append(marks, bookmark{Title: o.Title, Page: o.Page + offset, Kids: offsetOutline(o.Kids, offset, count)})
+	}
+	return marks
+}
+
+// Writes the outline and links as a pdfmark program for Ghostscript to run after the documents
+func writeOutline(name string, marks []bookmark, links []link) error {
+	var buf bytes.Buffer
+	writePdfmarks(&buf, marks)
+	for _, l := range links {
+		fmt.Fprintf(&buf, "[ /SrcPg %d /Rect [%.2f %.2f %.2f %.2f] /Border [0 0 0] /Page %d /View [/XYZ null null null] /Subtype /Link /ANN pdfmark\n",
+			l.SourcePage, l.Rect[0], l.Rect[1], l.Rect[2], l.Rect[3], l.Page)
+	}
+	return writeFile(name, buf.Bytes())
+}
+
+func writePdfmarks(buf *bytes.Buffer, marks []bookmark) {
+	for _, mark := range marks {
+		count := ""
+		if len(mark.Kids) > 0 {
+			// A negative count leaves the entry collapsed
+			count = fmt.Sprintf(" /Count -%d", len(mark.Kids))
+		}
+		fmt.Fprintf(buf, "[ /Title %s /Page %d /View [/XYZ null null null]%s /OUT pdfmark\n", pdfmarkString(mark.Title), mark.Page, count)
+		writePdfmarks(buf, mark.Kids)
+	}
+}
+
+// Encodes text as a UTF-16 hex string so titles need no escaping and keep non-ASCII characters
+func pdfmarkString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, r := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	b.WriteString(">")
+	return b.String()
+}
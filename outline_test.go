@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPdfmarkString(t *testing.T) {
+	if s := pdfmarkString("A(1)"); s != "<FEFF0041002800310029>" {
+		t.Errorf("Incorrect encoding, got %v", s)
+	}
+	if s := pdfmarkString("é"); s != "<FEFF00E9>" {
+		t.Errorf("Incorrect encoding, got %v", s)
+	}
+}
+
+func TestParseOutline(t *testing.T) {
+	contents := []byte(`{"version": 1, "outlines": [
+		{"title": "One", "destpageposfrom1": 1, "kids": [{"title": "Two", "destpageposfrom1": 3, "kids": []}]},
+		{"title": "Broken", "destpageposfrom1": null, "kids": []},
+		{"title": "Beyond", "destpageposfrom1": 9, "kids": []}
+	]}`)
+	outlines, err := parseOutline(contents)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	marks := offsetOutline(outlines, 10, 5)
+	if len(marks) != 1 {
+		t.Fatalf("Expected a single bookmark, got %v", marks)
+	}
+	if marks[0].Title != "One" || marks[0].Page != 11 {
+		t.Errorf("Incorrect bookmark, got %+v", marks[0])
+	}
+	if len(marks[0].Kids) != 1 || marks[0].Kids[0].Page != 13 {
+		t.Errorf("Incorrect nested bookmarks, got %+v", marks[0].Kids)
+	}
+}
+
+func TestWritePdfmarks(t *testing.T) {
+	var buf bytes.Buffer
+	writePdfmarks(&buf, []bookmark{
+		{Title: "A", Page: 1, Kids: []bookmark{{Title: "B", Page: 2}}},
+		{Title: "C", Page: 4},
+	})
+	expected := "[ /Title <FEFF0041> /Page 1 /View [/XYZ null null null] /Count -1 /OUT pdfmark\n" +
+		"[ /Title <FEFF0042> /Page 2 /View [/XYZ null null null] /OUT pdfmark\n" +
+		"[ /Title <FEFF0043> /Page 4 /View [/XYZ null null null] /OUT pdfmark\n"
+	if buf.String() != expected {
+		t.Errorf("Incorrect pdfmarks, got %v", buf.String())
+	}
+}
+
+func TestAssembleOffsetsLinks(t *testing.T) {
+	front := []*section{
+		{File: "a.pdf", Title: "A", PageCount: 2},
+		{File: "b.pdf", Title: "B", PageCount: 1, Links: []link{{SourcePage: 1, Page: 7}}},
+	}
+	files, marks, links := assemble(front, nil, nil)
+	if len(files) != 2 || marks[1].Page != 3 {
+		t.Errorf("Incorrect layout, got %v and %v", files, marks)
+	}
+	if len(links) != 1 || links[0].SourcePage != 3 || links[0].Page != 7 {
+		t.Errorf("Incorrect links, got %v", links)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// The parts of qpdf's json output describing pages and objects
+type qpdfDocument struct {
+	Pages []struct {
+		Object string `json:"object"`
+	} `json:"pages"`
+	Objects map[string]json.RawMessage `json:"objects"`
+}
+
+// A link annotation read back from a PDF
+type pdfLink struct {
+	Page int
+	Rect [4]float64
+	URI  string
+}
+
+// Size of a page in points as displayed, after its rotation
+type pageSize struct {
+	Width  float64
+	Height float64
+}
+
+func pageCount(file string) (int, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("qpdf", "--show-npages", file)
+	cmd.Stdout = &out
+	if err := run(cmd); err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(out.String()))
+}
+
+// qpdf exits with 3 when it succeeded but warned
+func qpdfWarned(err error) bool {
+	if te, ok := err.(*toolError); ok {
+		err = te.error
+	}
+	exit, ok := err.(*exec.ExitError)
+	return ok && exit.ExitCode() == 3
+}
+
+func readQpdfDocument(file string) (*qpdfDocument, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("qpdf", "--json", "--json-key=pages", "--json-key=objects", file)
+	cmd.Stdout = &out
+	if err := run(cmd); err != nil {
+		return nil, err
+	}
+	return parseQpdfDocument(out.Bytes())
+}
+
+func parseQpdfDocument(contents []byte) (*qpdfDocument, error) {
+	d := &qpdfDocument{}
+	if err := json.Unmarshal(contents, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Objects are either inline or a reference such as "12 0 R"
+func (d *qpdfDocument) resolve(raw json.RawMessage) json.RawMessage {
+	var ref string
+	if json.Unmarshal(raw, &ref) == nil {
+		return d.Objects[ref]
+	}
+	return raw
+}
+
+// Reads the media box and rotation of each page, following the page tree for inherited ones. Pages turned
+// a quarter have their width and height swapped, as qpdf keeps an overlay upright as displayed.
+func (d *qpdfDocument) pageSizes() []pageSize {
+	sizes := []pageSize{}
+	for _, p := range d.Pages {
+		var box *[4]float64
+		var rotate *int
+		node := d.Objects[p.Object]
+		for depth := 0; node != nil && depth < 32 && (box == nil || rotate == nil); depth++ {
+			fields := struct {
+				MediaBox json.RawMessage `json:"/MediaBox"`
+				Rotate   json.RawMessage `json:"/Rotate"`
+				Parent   json.RawMessage `json:"/Parent"`
+			}{}
+			if json.Unmarshal(node, &fields) != nil {
+				break
+			}
+			b := [4]float64{}
+			if box == nil && fields.MediaBox != nil && json.Unmarshal(d.resolve(fields.MediaBox), &b) == nil {
+				box = &b
+			}
+			r := 0
+			if rotate == nil && fields.Rotate != nil && json.Unmarshal(d.resolve(fields.Rotate), &r) == nil {
+				rotate = &r
+			}
+			if fields.Parent == nil {
+				break
+			}
+			node = d.resolve(fields.Parent)
+		}
+		size := pageSize{Width: 612, Height: 792}
+		if box != nil {
+			size = pageSize{Width: box[2] - box[0], Height: box[3] - box[1]}
+		}
+		if rotate != nil && ((*rotate%360)+360)%180 == 90 {
+			size.Width, size.Height = size.Height, size.Width
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes
+}
+
+// Reads the link annotations that open a URI
+func (d *qpdfDocument) links() []pdfLink {
+	links := []pdfLink{}
+	for i, p := range d.Pages {
+		page := struct {
+			Annots json.RawMessage `json:"/Annots"`
+		}{}
+		if err := json.Unmarshal(d.Objects[p.Object], &page); err != nil || page.Annots == nil {
+			continue
+		}
+		annots := []json.RawMessage{}
+		if err := json.Unmarshal(d.resolve(page.Annots), &annots); err != nil {
+			continue
+		}
+		for _, a := range annots {
+			annot := struct {
+				Subtype string          `json:"/Subtype"`
+				Rect    [4]float64      `json:"/Rect"`
+				Action  json.RawMessage `json:"/A"`
+			}{}
+			if err := json.Unmarshal(d.resolve(a), &annot); err != nil || annot.Subtype != "/Link" || annot.Action == nil {
+				continue
+			}
+			action := struct {
+				URI string `json:"/URI"`
+			}{}
+			if err := json.Unmarshal(d.resolve(annot.Action), &action); err != nil || action.URI == "" {
+				continue
+			}
+			links = append(links, pdfLink{Page: i + 1, Rect: annot.Rect, URI: action.URI})
+		}
+	}
+	return links
+}
+
+// Pages that draw no text, such as scans, judged by whether their resources or those of their forms name a font
+func (d *qpdfDocument) textlessPages() []int {
+	pages := []int{}
+	for i, p := range d.Pages {
+		if !d.hasFonts(d.pageResources(p.Object), 0) {
+			pages = append(pages, i+1)
+		}
+	}
+	return pages
+}
+
+// The resources of a page, following the page tree for inherited resources
+func (d *qpdfDocument) pageResources(object string) json.RawMessage {
+	node := d.Objects[object]
+	for depth := 0; node != nil && depth < 32; depth++ {
+		fields := struct {
+			Resources json.RawMessage `json:"/Resources"`
+			Parent    json.RawMessage `json:"/Parent"`
+		}{}
+		if json.Unmarshal(node, &fields) != nil {
+			break
+		}
+		if fields.Resources != nil {
+			return d.resolve(fields.Resources)
+		}
+		if fields.Parent == nil {
+			break
+		}
+		node = d.resolve(fields.Parent)
+	}
+	return nil
+}
+
+func (d *qpdfDocument) hasFonts(resources json.RawMessage, depth int) bool {
+	fields := struct {
+		Font    json.RawMessage `json:"/Font"`
+		XObject json.RawMessage `json:"/XObject"`
+	}{}
+	if resources == nil || depth > 8 || json.Unmarshal(resources, &fields) != nil {
+		return false
+	}
+	fonts := map[string]json.RawMessage{}
+	if fields.Font != nil && json.Unmarshal(d.resolve(fields.Font), &fonts) == nil && len(fonts) > 0 {
+		return true
+	}
+	xobjects := map[string]json.RawMessage{}
+	if fields.XObject == nil || json.Unmarshal(d.resolve(fields.XObject), &xobjects) != nil {
+		return false
+	}
+	for _, x := range xobjects {
+		form := struct {
+			Subtype   string          `json:"/Subtype"`
+			Resources json.RawMessage `json:"/Resources"`
+		}{}
+		if json.Unmarshal(d.resolve(x), &form) == nil && form.Subtype == "/Form" && d.hasFonts(d.resolve(form.Resources), depth+1) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestParseLinks(t *testing.T) {
+	contents := []byte(`{
+		"version": 1,
+		"pages": [{"object": "3 0 R", "pageposfrom1": 1}, {"object": "4 0 R", "pageposfrom1": 2}],
+		"objects": {
+			"3 0 R": {"/Type": "/Page", "/Annots": ["10 0 R", {"/Subtype": "/Widget"}]},
+			"4 0 R": {"/Type": "/Page", "/Annots": "11 0 R"},
+			"10 0 R": {"/Subtype": "/Link", "/Rect": [10, 20, 110, 40], "/A": {"/S": "/URI", "/URI": "https://frisket.invalid/page/3"}},
+			"11 0 R": [{"/Subtype": "/Link", "/Rect": [1, 2, 3, 4], "/A": "12 0 R"}],
+			"12 0 R": {"/S": "/URI", "/URI": "https://frisket.invalid/page/9"}
+		}
+	}`)
+	d, err := parseQpdfDocument(contents)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	links := d.links()
+	if len(links) != 2 {
+		t.Fatalf("Expected two links, got %v", links)
+	}
+	if links[0].Page != 1 || links[0].Rect != [4]float64{10, 20, 110, 40} || links[0].URI != contentsLinkPrefix+"3" {
+		t.Errorf("Incorrect link, got %+v", links[0])
+	}
+	if links[1].Page != 2 || links[1].URI != contentsLinkPrefix+"9" {
+		t.Errorf("Incorrect link, got %+v", links[1])
+	}
+}
+
+func TestPageSizes(t *testing.T) {
+	contents := []byte(`{
+		"pages": [{"object": "3 0 R"}, {"object": "4 0 R"}, {"object": "5 0 R"}, {"object": "7 0 R"}, {"object": "8 0 R"}, {"object": "9 0 R"}],
+		"objects": {
+			"2 0 R": {"/Type": "/Pages", "/MediaBox": [0, 0, 595, 842]},
+			"3 0 R": {"/Type": "/Page", "/Parent": "2 0 R"},
+			"4 0 R": {"/Type": "/Page", "/Parent": "2 0 R", "/MediaBox": "6 0 R"},
+			"5 0 R": {"/Type": "/Page"},
+			"6 0 R": [10, 10, 622, 802],
+			"7 0 R": {"/Type": "/Page", "/Parent": "2 0 R", "/Rotate": 90},
+			"8 0 R": {"/Type": "/Page", "/Parent": "10 0 R", "/Rotate": 180},
+			"9 0 R": {"/Type": "/Page", "/Parent": "10 0 R"},
+			"10 0 R": {"/Type": "/Pages", "/Parent": "2 0 R", "/Rotate": -270}
+		}
+	}`)
+	d, err := parseQpdfDocument(contents)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []pageSize{{595, 842}, {612, 792}, {612, 792}, {842, 595}, {595, 842}, {842, 595}}
+	sizes := d.pageSizes()
+	if len(sizes) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, sizes)
+	}
+	for i := range expected {
+		if sizes[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, sizes)
+		}
+	}
+}
+
+func TestTextlessPages(t *testing.T) {
+	contents := []byte(`{
+		"pages": [{"object": "3 0 R"}, {"object": "4 0 R"}, {"object": "5 0 R"}, {"object": "6 0 R"}],
+		"objects": {
+			"2 0 R": {"/Type": "/Pages", "/Resources": {"/Font": {"/F1": "10 0 R"}}},
+			"3 0 R": {"/Type": "/Page", "/Parent": "2 0 R"},
+			"4 0 R": {"/Type": "/Page", "/Parent": "2 0 R", "/Resources": {"/XObject": {"/Im1": "11 0 R"}}},
+			"5 0 R": {"/Type": "/Page", "/Resources": "7 0 R"},
+			"6 0 R": {"/Type": "/Page", "/Resources": {"/Font": {}}},
+			"7 0 R": {"/XObject": {"/Fm1": "12 0 R"}},
+			"11 0 R": {"/Subtype": "/Image"},
+			"12 0 R": {"/Subtype": "/Form", "/Resources": {"/Font": "13 0 R"}},
+			"13 0 R": {"/F2": "10 0 R"}
+		}
+	}`)
+	d, err := parseQpdfDocument(contents)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []int{2, 4}
+	if pages := d.textlessPages(); len(pages) != 2 || pages[0] != 2 || pages[1] != 4 {
+		t.Errorf("Expected %v, got %v", expected, pages)
+	}
+}
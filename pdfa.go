@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+var iccProfile = flag.String("icc-profile", "/usr/share/color/icc/ghostscript/srgb.icc", "RGB ICC profile embedded as the output intent of PDF/A output")
+
+// The PDF/A conformance levels a job may ask for and the part of the standard each belongs to
+var pdfaParts = map[string]string{"1b": "1", "2b": "2"}
+
+// The outcome of checking an output against PDF/A
+type pdfaResult struct {
+	Level      string   `json:"level"`
+	Conformant bool     `json:"conformant"`
+	Issues     []string `json:"issues,omitempty"`
+}
+
+// Rewrites the file as PDF/A with an sRGB output intent and embedded fonts. Ghostscript does not carry
+// an outline through from its input so the outline's pdfmarks, when there are any, are run again.
+func archive(file, level, title, outline string, settings []string) error {
+	part, ok := pdfaParts[level]
+	if !ok {
+		return fmt.Errorf("Unknown PDF/A level %q", level)
+	}
+	def := "processing/PDFA_def.ps"
+	if err := writeFile(def, []byte(fmt.Sprintf(pdfaDef, psString(*iccProfile), pdfmarkString(title)))); err != nil {
+		return err
+	}
+	args := append([]string{
+		"-dPDFA=" + part, "-dBATCH", "-dNOPAUSE", "-dQUIET", "-dNOOUTERSAVE", "-dPDFACompatibilityPolicy=1",
+		"-sColorConversionStrategy=RGB", "-sProcessColorModel=DeviceRGB", "-dEmbedAllFonts=true", "-dSubsetFonts=true",
+		"--permit-file-read=" + *iccProfile,
+	}, settings...)
+	return fromScratch(file, func(archival string) error {
+		args = append(args, "-sDEVICE=pdfwrite", "-sOutputFile="+archival, def, file)
+		if outline != "" {
+			args = append(args, outline)
+		}
+		return run(exec.Command("gs", args...))
+	})
+}
+
+// Checks the parts of PDF/A that go wrong most often: encryption, the output intent, XMP metadata and unembedded fonts
+func checkPDFA(file, level string) *pdfaResult {
+	result := &pdfaResult{Level: level}
+	d, err := readQpdfDocument(file)
+	if err != nil {
+		result.Issues = []string{fmt.Sprintf("could not be read: %v", err)}
+		return result
+	}
+	result.Issues = d.pdfaIssues()
+	result.Conformant = len(result.Issues) == 0
+	if !result.Conformant {
+		infoLog.Printf("%s falls short of PDF/A-%s: %s\n", file, level, strings.Join(result.Issues, ", "))
+	}
+	return result
+}
+
+func (d *qpdfDocument) pdfaIssues() []string {
+	issues := []string{}
+	trailer := struct {
+		Root    json.RawMessage `json:"/Root"`
+		Encrypt json.RawMessage `json:"/Encrypt"`
+	}{}
+	json.Unmarshal(d.Objects["trailer"], &trailer)
+	if trailer.Encrypt != nil {
+		issues = append(issues, "encrypted")
+	}
+	catalog := struct {
+		OutputIntents json.RawMessage `json:"/OutputIntents"`
+		Metadata      json.RawMessage `json:"/Metadata"`
+	}{}
+	if trailer.Root != nil {
+		json.Unmarshal(d.resolve(trailer.Root), &catalog)
+	}
+	if catalog.OutputIntents == nil {
+		issues = append(issues, "no output intent")
+	}
+	if catalog.Metadata == nil {
+		issues = append(issues, "no XMP metadata")
+	}
+
+	fonts := []string{}
+	for _, raw := range d.Objects {
+		font := struct {
+			Type           string          `json:"/Type"`
+			Subtype        string          `json:"/Subtype"`
+			BaseFont       string          `json:"/BaseFont"`
+			FontDescriptor json.RawMessage `json:"/FontDescriptor"`
+		}{}
+		// Composite and Type 3 fonts carry their glyphs elsewhere
+		if json.Unmarshal(raw, &font) != nil || font.Type != "/Font" || font.Subtype == "/Type0" || font.Subtype == "/Type3" {
+			continue
+		}
+		descriptor := map[string]json.RawMessage{}
+		if font.FontDescriptor != nil {
+			json.Unmarshal(d.resolve(font.FontDescriptor), &descriptor)
+		}
+		if descriptor["/FontFile"] == nil && descriptor["/FontFile2"] == nil && descriptor["/FontFile3"] == nil {
+			fonts = append(fonts, fmt.Sprintf("font %s is not embedded", strings.TrimPrefix(font.BaseFont, "/")))
+		}
+	}
+	sort.Strings(fonts)
+	return append(issues, fonts...)
+}
+
+// Declares the output intent PDF/A requires, adapted from Ghostscript's PDFA_def.ps
+const pdfaDef = `%%!
+/ICCProfile %s def
+[ /Title %s /DOCINFO pdfmark
+[/_objdef {icc_PDFA} /type /stream /OBJ pdfmark
+[{icc_PDFA} << /N 3 >> /PUT pdfmark
+[{icc_PDFA} ICCProfile (r) file /PUT pdfmark
+[/_objdef {OutputIntent_PDFA} /type /dict /OBJ pdfmark
+[{OutputIntent_PDFA} << /Type /OutputIntent /S /GTS_PDFA1 /DestOutputProfile {icc_PDFA} /OutputConditionIdentifier (sRGB) >> /PUT pdfmark
+[{Catalog} << /OutputIntents [ {OutputIntent_PDFA} ] >> /PUT pdfmark
+`
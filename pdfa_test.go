@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPDFAIssues(t *testing.T) {
+	contents := []byte(`{
+		"pages": [{"object": "3 0 R"}],
+		"objects": {
+			"trailer": {"/Root": "1 0 R", "/Encrypt": "9 0 R"},
+			"1 0 R": {"/Type": "/Catalog", "/Metadata": "8 0 R"},
+			"3 0 R": {"/Type": "/Page"},
+			"4 0 R": {"/Type": "/Font", "/Subtype": "/Type1", "/BaseFont": "/Helvetica"},
+			"5 0 R": {"/Type": "/Font", "/Subtype": "/TrueType", "/BaseFont": "/ABCDEF+Arial", "/FontDescriptor": "6 0 R"},
+			"6 0 R": {"/Type": "/FontDescriptor", "/FontFile2": "7 0 R"},
+			"10 0 R": {"/Type": "/Font", "/Subtype": "/Type3"}
+		}
+	}`)
+	d, err := parseQpdfDocument(contents)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []string{"encrypted", "no output intent", "font Helvetica is not embedded"}
+	if issues := d.pdfaIssues(); !reflect.DeepEqual(issues, expected) {
+		t.Errorf("Expected %v, got %v", expected, issues)
+	}
+}
+
+func TestPDFAConformant(t *testing.T) {
+	contents := []byte(`{
+		"pages": [],
+		"objects": {
+			"trailer": {"/Root": "1 0 R"},
+			"1 0 R": {"/Type": "/Catalog", "/Metadata": "8 0 R", "/OutputIntents": ["2 0 R"]}
+		}
+	}`)
+	d, err := parseQpdfDocument(contents)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if issues := d.pdfaIssues(); len(issues) != 0 {
+		t.Errorf("Expected no issues, got %v", issues)
+	}
+}
+
+func TestParseJobPDFA(t *testing.T) {
+	if j, err := parseJob(`{"key": "bundle", "pdfa": "2b"}`); err != nil || j.PDFA != "2b" {
+		t.Errorf("Expected PDF/A-2b, got %+v %v", j, err)
+	}
+	if _, err := parseJob(`{"key": "bundle", "pdfa": "3u"}`); err == nil {
+		t.Error("Expected an error for an unknown PDF/A level")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os/exec"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// Where subsets of the documents are stitched while looking for damaged ones
+const probeFile = "processing/probe.pdf"
+
+// Checks each converted PDF with qpdf, rewriting those with problems and leaving out those beyond repair
+func preflightFiles(docs []*document, parentSp opentracing.Span) {
+	preflightSp := opentracing.StartSpan("Preflight", opentracing.ChildOf(parentSp.Context()))
+	defer preflightSp.Finish()
+	for _, doc := range docs {
+		if doc.Output == "" || run(exec.Command("qpdf", "--check", doc.Output)) == nil {
+			continue
+		}
+		if err := repairPDF(doc.Output); err != nil {
+			doc.damaged(err, "could not be repaired")
+			continue
+		}
+		infoLog.Printf("%s repaired\n", doc.Path)
+		doc.Repaired = true
+	}
+}
+
+// Rewrites the PDF with qpdf, which rebuilds broken cross reference tables, or failing that with Ghostscript
+func repairPDF(file string) error {
+	return fromScratch(file, func(repaired string) error {
+		err := run(exec.Command("qpdf", file, repaired))
+		if err != nil && !qpdfWarned(err) {
+			return run(exec.Command("gs", "-dBATCH", "-dNOPAUSE", "-dQUIET", "-sDEVICE=pdfwrite", "-sOutputFile="+repaired, file))
+		}
+		return nil
+	})
+}
+
+// Concatenates the files with Ghostscript, retrying at compatibility level 1.3 which gets past some malformed inputs
+func stitch(files []string, output string, settings []string) error {
+	return fromScratch(output, func(stitched string) error {
+		args := append(append([]string{"-dBATCH", "-dPrinted=false", "-dNOPAUSE", "-dPDFFitPage"}, settings...), "-sDEVICE=pdfwrite", "-sOutputFile="+stitched)
+		err := run(exec.Command("gs", append(args, files...)...))
+		if err != nil {
+			err = run(exec.Command("gs", append(append([]string{"-dCompatibilityLevel=1.3"}, args...), files...)...))
+		}
+		return err
+	})
+}
+
+// The converted documents that go into the output
+func stitched(docs []*document) []*document {
+	included := []*document{}
+	for _, doc := range docs {
+		if doc.Output != "" {
+			included = append(included, doc)
+		}
+	}
+	return included
+}
+
+func outputs(docs []*document) []string {
+	files := []string{}
+	for _, doc := range docs {
+		files = append(files, doc.Output)
+	}
+	return files
+}
+
+// Bisects documents whose output failed to stitch, leaving out each that fails on its own. The documents are probed
+// together first, so none is blamed when the failure lies elsewhere, such as in the summary or table of contents.
+// Returns how many were left out, none when the failure only shows up in combination.
+func findDamaged(docs []*document, probe func([]*document) error) int {
+	if len(docs) == 0 {
+		return 0
+	}
+	err := probe(docs)
+	if err == nil {
+		return 0
+	}
+	if len(docs) == 1 {
+		docs[0].damaged(err, "could not be stitched")
+		return 1
+	}
+	found := 0
+	for _, half := range [][]*document{docs[:len(docs)/2], docs[len(docs)/2:]} {
+		found += findDamaged(half, probe)
+	}
+	return found
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFindDamaged(t *testing.T) {
+	docs := []*document{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		docs = append(docs, &document{Path: name, Output: name + ".pdf"})
+	}
+	bad := map[string]bool{"c.pdf": true, "f.pdf": true}
+	probes := 0
+	probe := func(docs []*document) error {
+		probes++
+		for _, doc := range docs {
+			if bad[doc.Output] {
+				return errors.New("gs failed")
+			}
+		}
+		return nil
+	}
+	if found := findDamaged(docs, probe); found != 2 {
+		t.Fatalf("Expected two damaged documents, found %d", found)
+	}
+	for _, doc := range docs {
+		if bad[doc.Path+".pdf"] != doc.excluded() {
+			t.Errorf("Incorrect exclusion of %s, got %+v", doc.Path, doc)
+		}
+	}
+	if docs[2].Reason != reasonDamaged || docs[2].Output != "" {
+		t.Errorf("Expected c to be left out as damaged, got %+v", docs[2])
+	}
+	if probes >= 2*len(docs) {
+		t.Errorf("Expected bisection to probe fewer than %d times, got %d", 2*len(docs), probes)
+	}
+	if stitched := stitched(docs); len(stitched) != 5 {
+		t.Errorf("Expected five documents left to stitch, got %d", len(stitched))
+	}
+}
+
+func TestFindDamagedSingleDocument(t *testing.T) {
+	docs := []*document{{Path: "a", Output: "a.pdf"}}
+	if found := findDamaged(docs, func([]*document) error { return nil }); found != 0 || docs[0].excluded() {
+		t.Errorf("Expected a document that stitches on its own to stay in, got %d %+v", found, docs[0])
+	}
+	if found := findDamaged(docs, func([]*document) error { return errors.New("gs failed") }); found != 1 || docs[0].Reason != reasonDamaged {
+		t.Errorf("Expected a document that fails on its own to be left out, got %d %+v", found, docs[0])
+	}
+}
+
+func TestFindDamagedInCombination(t *testing.T) {
+	docs := []*document{{Path: "a", Output: "a.pdf"}, {Path: "b", Output: "b.pdf"}}
+	if found := findDamaged(docs, func([]*document) error { return nil }); found != 0 {
+		t.Errorf("Expected nothing to be left out, found %d", found)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/russross/blackfriday"
+)
+
+// Renders text formats as an HTML page that is then printed like any other HTML
+type renderedConverter struct {
+	name   string
+	types  []string
+	render func(contents []byte, doc *document) documentPage
+}
+
+// The page a rendered document becomes
+type documentPage struct {
+	Title       string
+	Class       string
+	Body        template.HTML
+	PageNumbers bool // Number the pages unless the document has its own header or footer
+}
+
+func (c renderedConverter) Name() string    { return c.name }
+func (c renderedConverter) Types() []string { return c.types }
+func (renderedConverter) Priority() int     { return 0 }
+
+// Writes the page next to the document in the bundle, so its relative references resolve, and prints it
+func (c renderedConverter) Convert(doc *document, output string, sp opentracing.Span) error {
+	contents, err := ioutil.ReadFile(doc.Source)
+	if err != nil {
+		return err
+	}
+	page := c.render(contents, doc)
+	page.Title = doc.Title
+	rendered := *doc
+	rendered.Path = path.Join(path.Dir(doc.Path), fmt.Sprintf(".rendered-%d.html", doc.id))
+	var buf bytes.Buffer
+	if err := documentPageTemplate.Execute(&buf, page); err != nil {
+		return err
+	}
+	file := filepath.Join(bundleDir, filepath.FromSlash(rendered.Path))
+	if err := writeFile(file, buf.Bytes()); err != nil {
+		return err
+	}
+	if page.PageNumbers && rendered.HTML.Header == "" && rendered.HTML.Footer == "" {
+		rendered.HTML.Footer = pageNumberFooter
+		if rendered.HTML.MarginBottom == "" {
+			rendered.HTML.MarginBottom = "15mm"
+		}
+	}
+	html := converterFor("text/html", doc.htmlConverter)
+	if html == nil {
+		return fmt.Errorf("No converter for HTML")
+	}
+	err = html.Convert(&rendered, output, sp)
+	doc.Warnings = rendered.Warnings
+	return err
+}
+
+var textConverter = renderedConverter{"text", []string{"text/plain"}, renderText}
+var markdownConverter = renderedConverter{"markdown", []string{"text/markdown", "text/x-markdown"}, renderMarkdown}
+var csvConverter = renderedConverter{"csv", []string{"text/csv"}, renderCSV}
+var jsonConverter = renderedConverter{"json", []string{"application/json"}, renderJSON}
+
+// Monospace with long lines wrapped
+func renderText(contents []byte, doc *document) documentPage {
+	return documentPage{Class: "text", Body: template.HTML("<pre>" + template.HTMLEscapeString(string(contents)) + "</pre>"), PageNumbers: true}
+}
+
+func renderMarkdown(contents []byte, doc *document) documentPage {
+	return documentPage{Class: "markdown", Body: template.HTML(blackfriday.MarkdownCommon(contents))}
+}
+
+// A table whose first row is repeated at the top of every page
+func renderCSV(contents []byte, doc *document) documentPage {
+	reader := csv.NewReader(bytes.NewReader(contents))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		doc.warn(fmt.Sprintf("rendered as text, not valid CSV: %v", err))
+		return renderText(contents, doc)
+	}
+	var body strings.Builder
+	body.WriteString("<table>")
+	for i, record := range records {
+		cell := "td"
+		if i == 0 {
+			cell = "th"
+			body.WriteString("<thead>")
+		}
+		body.WriteString("<tr>")
+		for _, field := range record {
+			fmt.Fprintf(&body, "<%s>%s</%s>", cell, template.HTMLEscapeString(field), cell)
+		}
+		body.WriteString("</tr>")
+		if i == 0 {
+			body.WriteString("</thead><tbody>")
+		}
+	}
+	if len(records) > 0 {
+		body.WriteString("</tbody>")
+	}
+	body.WriteString("</table>")
+	return documentPage{Class: "csv", Body: template.HTML(body.String()), PageNumbers: true}
+}
+
+// Pretty printed with two space indents
+func renderJSON(contents []byte, doc *document) documentPage {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, bytes.TrimPrefix(contents, []byte("\xef\xbb\xbf")), "", "  "); err != nil {
+		doc.warn(fmt.Sprintf("rendered as text, not valid JSON: %v", err))
+		return renderText(contents, doc)
+	}
+	return renderText(indented.Bytes(), doc)
+}
+
+// Filled in by the HTML converters like any other footer
+const pageNumberFooter = `<div style="width: 100%; font-size: 8px; text-align: center;"><span class="pageNumber"></span> / <span class="totalPages"></span></div>`
+
+var documentPageTemplate = template.Must(template.New("page").Parse(documentPageHTML))
+
+const documentPageHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style type="text/css">
+body { font-family: Verdana, Arial, Helvetica, "PT Sans", sans-serif; font-size: 10pt; }
+pre { font-family: "DejaVu Sans Mono", "Liberation Mono", Courier, monospace; font-size: 9pt; margin: 0; white-space: pre-wrap; word-wrap: break-word; }
+table { border-collapse: collapse; width: 100%; font-size: 8pt; }
+thead { display: table-header-group; }
+tr { page-break-inside: avoid; }
+th { background-color: #EEEEEE; text-align: left; }
+td, th { border: 1px solid #999999; padding: 2px 4px; vertical-align: top; word-wrap: break-word; }
+.markdown img { max-width: 100%; }
+.markdown pre { background-color: #F6F6F6; padding: 4px; }
+.markdown code { font-family: "DejaVu Sans Mono", "Liberation Mono", Courier, monospace; }
+</style>
+</head>
+<body class="{{.Class}}">
+{{.Body}}
+</body>
+</html>
+`
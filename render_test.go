@@ -0,0 +1,171 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+func TestRefineType(t *testing.T) {
+	for _, c := range []struct{ content, path, expected string }{
+		{"text/plain", "notes/README.md", "text/markdown"},
+		{"text/plain", "data.CSV", "text/csv"},
+		{"text/plain", "config.json", "application/json"},
+		{"text/plain", "notes.txt", "text/plain"},
+		{"application/pdf", "mislabelled.json", "application/pdf"},
+		{"application/zip", "Budget.XLSX", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"application/octet-stream", "deck.ppt", "application/vnd.ms-powerpoint"},
+		{"application/zip", "notes.md", "application/zip"},
+	} {
+		if content := refineType(c.content, c.path); content != c.expected {
+			t.Errorf("Expected %s for %s, got %s", c.expected, c.path, content)
+		}
+	}
+}
+
+func TestRenderCSV(t *testing.T) {
+	doc := &document{}
+	page := renderCSV([]byte("name,note\n\"Smith, J\",<b>bold</b>\nshort\n"), doc)
+	body := string(page.Body)
+	for _, expected := range []string{"<thead><tr><th>name</th><th>note</th></tr></thead>", "<td>Smith, J</td><td>&lt;b&gt;bold&lt;/b&gt;</td>", "<tr><td>short</td></tr>"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected %q in %s", expected, body)
+		}
+	}
+	if !page.PageNumbers || len(doc.Warnings) > 0 {
+		t.Errorf("Incorrect page, got %+v and warnings %v", page, doc.Warnings)
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	doc := &document{}
+	page := renderJSON([]byte(`{"a":[1,2],"b":"<c>"}`), doc)
+	expected := "<pre>{\n  &#34;a&#34;: [\n    1,\n    2\n  ],\n  &#34;b&#34;: &#34;&lt;c&gt;&#34;\n}</pre>"
+	if string(page.Body) != expected {
+		t.Errorf("Expected %q, got %q", expected, page.Body)
+	}
+	renderJSON([]byte(`{"a":`), doc)
+	if len(doc.Warnings) != 1 {
+		t.Errorf("Expected a warning about invalid JSON, got %v", doc.Warnings)
+	}
+}
+
+// Records the document it was asked to print
+type recordingConverter struct{ printed *document }
+
+func (recordingConverter) Name() string    { return "recording" }
+func (recordingConverter) Types() []string { return []string{"text/html"} }
+func (recordingConverter) Priority() int   { return 0 }
+func (c recordingConverter) Convert(doc *document, output string, sp opentracing.Span) error {
+	*c.printed = *doc
+	doc.warn("blocked request for http://example.com/")
+	return nil
+}
+
+func TestRenderedConverter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	os.MkdirAll(filepath.Join(bundleDir, "docs"), 0755)
+	source := filepath.Join(bundleDir, "docs", "README.md")
+	ioutil.WriteFile(source, []byte("# Title\n\n![logo](img/logo.png)\n"), 0644)
+
+	printed := &document{}
+	defer func(registered []converter) { converters = registered }(converters)
+	converters = []converter{recordingConverter{printed}}
+	doc := &document{id: 3, Path: "docs/README.md", Source: source, Title: "Read me", htmlConverter: "recording"}
+	if err := markdownConverter.Convert(doc, "out.pdf", opentracing.StartSpan("test")); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if printed.Path != "docs/.rendered-3.html" || printed.HTML.Footer != "" {
+		t.Errorf("Incorrect page printed, got %+v", printed)
+	}
+	html, _ := ioutil.ReadFile(filepath.Join(bundleDir, "docs", ".rendered-3.html"))
+	for _, expected := range []string{"<title>Read me</title>", "<h1>Title</h1>", `<img src="img/logo.png" alt="logo"`} {
+		if !strings.Contains(string(html), expected) {
+			t.Errorf("Expected %q in %s", expected, html)
+		}
+	}
+	if len(doc.Warnings) != 1 {
+		t.Errorf("Expected the printer's warnings on the document, got %v", doc.Warnings)
+	}
+
+	ioutil.WriteFile(source, []byte("plain"), 0644)
+	if err := textConverter.Convert(doc, "out.pdf", opentracing.StartSpan("test")); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if printed.HTML.Footer != pageNumberFooter || printed.HTML.MarginBottom != "15mm" {
+		t.Errorf("Expected numbered pages, got %+v", printed.HTML)
+	}
+}
+
+func TestConvertDocumentCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	os.MkdirAll(bundleDir, 0755)
+	source := filepath.Join(bundleDir, "small.csv")
+	ioutil.WriteFile(source, []byte("name,age\nSmith,42\n"), 0644)
+
+	printed := &document{}
+	defer func(registered []converter) { converters = registered }(converters)
+	converters = append(converters, recordingConverter{printed})
+	// Office options that mean nothing to the CSV converter do not fail it
+	doc := &document{id: 1, Path: "small.csv", Source: source, Options: map[string]string{"landscape": "please", "notes": "maybe"}}
+	if perr := convertDocument(&job{HTMLConverter: "recording"}, doc, opentracing.StartSpan("test")); perr != nil {
+		t.Fatalf("Unexpected error %v", perr)
+	}
+	if doc.Type != "text/csv" || doc.Converter != "csv" || doc.Status != statusConverted {
+		t.Errorf("Expected a short CSV to be converted as CSV, got %+v", doc)
+	}
+	html, _ := ioutil.ReadFile(filepath.Join(bundleDir, ".rendered-1.html"))
+	if !strings.Contains(string(html), "<td>Smith</td><td>42</td>") {
+		t.Errorf("Expected the table in %s", html)
+	}
+}
+
+func TestConvertDocumentWindows1252(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	os.MkdirAll(bundleDir, 0755)
+	source := filepath.Join(bundleDir, "notes.txt")
+	ioutil.WriteFile(source, []byte("Caf\xe9 \x80 5\r\n"), 0644)
+
+	printed := &document{}
+	defer func(registered []converter) { converters = registered }(converters)
+	converters = append(converters, recordingConverter{printed})
+	doc := &document{id: 4, Path: "notes.txt", Source: source}
+	if perr := convertDocument(&job{HTMLConverter: "recording"}, doc, opentracing.StartSpan("test")); perr != nil {
+		t.Fatalf("Unexpected error %v", perr)
+	}
+	if doc.Encoding != encoding1252 || doc.Converter != "text" || doc.Status != statusConverted {
+		t.Errorf("Expected short Windows-1252 text to be transcoded and converted, got %+v", doc)
+	}
+	if original, _ := ioutil.ReadFile(source); string(original) != "Caf\xe9 \x80 5\r\n" {
+		t.Errorf("Expected the bundle's file to be untouched, got %q", original)
+	}
+	html, _ := ioutil.ReadFile(filepath.Join(bundleDir, ".rendered-4.html"))
+	if !strings.Contains(string(html), "Café € 5\n") {
+		t.Errorf("Expected the transcoded text in %s", html)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/opentracing/opentracing-go"
+)
+
+// What became of a job, uploaded alongside the PDF as <name>.json
+type jobResult struct {
+	Key        string            `json:"key"`
+	Output     string            `json:"output,omitempty"` // The uploaded PDF, empty when the output was split into parts
+	Outcome    string            `json:"outcome"`          // complete when every document made it into the output, otherwise partial
+	Pages      int               `json:"pages"`
+	SizeBefore int64             `json:"size_before"` // Bytes of the PDFs stitched together
+	SizeAfter  int64             `json:"size_after"`  // Bytes of the finished output
+	Started    time.Time         `json:"started"`
+	Finished   time.Time         `json:"finished"`
+	Timings    map[string]int64  `json:"timings"` // Milliseconds spent in each stage
+	Tools      map[string]string `json:"tools"`   // Versions of the tools the job ran
+	Parts      []part            `json:"parts,omitempty"`
+	Thumbnails []string          `json:"thumbnails,omitempty"` // Keys of the page previews
+	Text       string            `json:"text,omitempty"`       // Key of the extracted text
+	PDFA       *pdfaResult       `json:"pdfa,omitempty"`
+	Documents  []documentResult  `json:"documents"`
+}
+
+type documentResult struct {
+	Path       string      `json:"path"`
+	Title      string      `json:"title"`
+	Size       int64       `json:"size"`
+	SHA256     string      `json:"sha256,omitempty"`
+	Type       string      `json:"type,omitempty"`
+	Encoding   string      `json:"encoding,omitempty"`
+	Converter  string      `json:"converter,omitempty"`
+	Delivered  string      `json:"delivered,omitempty"`
+	Repaired   bool        `json:"repaired,omitempty"`
+	Status     string      `json:"status"`
+	Reason     string      `json:"reason,omitempty"`
+	Detail     string      `json:"detail,omitempty"`
+	DurationMS int64       `json:"duration_ms"`
+	FirstPage  int         `json:"first_page,omitempty"`
+	LastPage   int         `json:"last_page,omitempty"`
+	PageCount  int         `json:"page_count,omitempty"`
+	Bates      *batesRange `json:"bates,omitempty"`
+
+	OCRPages      int     `json:"ocr_pages,omitempty"`
+	OCRConfidence float64 `json:"ocr_confidence,omitempty"`
+
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// The Bates numbers of a document's first and last pages
+type batesRange struct {
+	First string `json:"first"`
+	Last  string `json:"last"`
+}
+
+const (
+	outcomeComplete = "complete"
+	outcomePartial  = "partial"
+)
+
+var versions map[string]string
+var versionsOnce sync.Once
+
+func newJobResult(j *job) *jobResult {
+	return &jobResult{Key: j.Key, Output: j.Key + ".pdf", Started: time.Now(), Timings: map[string]int64{}, Documents: []documentResult{}}
+}
+
+// Records how long a stage of the job took
+func (r *jobResult) timed(stage string, start time.Time) {
+	r.Timings[stage] = int64(time.Since(start) / time.Millisecond)
+}
+
+// Records what became of each document once the output is finished
+func (r *jobResult) finish(j *job, docs []*document) {
+	r.Finished = time.Now()
+	r.Tools = toolVersions()
+	r.Outcome = outcomeComplete
+	for _, doc := range docs {
+		d := documentResult{
+			Path:          doc.Path,
+			Title:         doc.Title,
+			Size:          doc.Size,
+			SHA256:        doc.SHA256,
+			Type:          doc.Type,
+			Encoding:      doc.Encoding,
+			Converter:     doc.Converter,
+			Delivered:     doc.Delivered,
+			Repaired:      doc.Repaired,
+			Status:        doc.Status,
+			Reason:        doc.Reason,
+			Detail:        doc.Detail,
+			DurationMS:    int64(doc.Duration / time.Millisecond),
+			OCRPages:      doc.OCRPages,
+			OCRConfidence: doc.OCRConfidence,
+			Warnings:      doc.Warnings,
+		}
+		if doc.excluded() {
+			r.Outcome = outcomePartial
+		}
+		d.PageCount = doc.PageCount
+		if doc.FirstPage > 0 && doc.PageCount > 0 {
+			d.FirstPage = doc.FirstPage
+			d.LastPage = doc.FirstPage + doc.PageCount - 1
+			if j.Bates != nil {
+				d.Bates = &batesRange{j.Bates.number(d.FirstPage), j.Bates.number(d.LastPage)}
+			}
+		}
+		r.Documents = append(r.Documents, d)
+	}
+}
+
+// The first line each tool prints for --version, looked up once per worker
+func toolVersions() map[string]string {
+	versionsOnce.Do(func() {
+		versions = map[string]string{}
+		for _, tool := range []string{"gs", "qpdf", "wkhtmltopdf", "lowriter", "tesseract", *chromiumPath} {
+			var out bytes.Buffer
+			cmd := exec.Command(tool, "--version")
+			cmd.Stdout = &out
+			if err := run(cmd); err != nil {
+				continue
+			}
+			versions[tool] = strings.TrimSpace(strings.SplitN(out.String(), "\n", 2)[0])
+		}
+	})
+	return versions
+}
+
+func uploadResult(result *jobResult, parentSp opentracing.Span) *processingError {
+	body, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return &processingError{fmt.Errorf("Could not encode result, err: %v", err.Error()), 561}
+	}
+	putParams := &s3.PutObjectInput{
+		Bucket:      &awsDoneBucket,
+		Key:         aws.String(result.Key + ".json"),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}
+	putSp := opentracing.StartSpan("PutObject", opentracing.ChildOf(parentSp.Context()))
+	_, err = s3session.PutObject(putParams)
+	putSp.Finish()
+	if err != nil {
+		return &processingError{fmt.Errorf("Could not upload result, err: %v", err.Error()), 560}
+	}
+	return nil
+}
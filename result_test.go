@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobResultFinish(t *testing.T) {
+	versionsOnce.Do(func() {})
+	j := &job{Key: "bundle", Bates: &bates{Prefix: "X", Digits: 3, Start: 10}}
+	docs := []*document{
+		{Path: "a.pdf", Title: "A", Size: 10, SHA256: "abc", Status: statusConverted, Converter: "passthrough", FirstPage: 2, PageCount: 3, Duration: 1500 * time.Millisecond},
+		{Path: "b.pdf", Title: "B", Status: statusFailed, Reason: reasonExitCode, Detail: "1"},
+	}
+	result := newJobResult(j)
+	result.timed("convert", time.Now().Add(-2*time.Second))
+	result.finish(j, docs)
+	if result.Output != "bundle.pdf" || result.Outcome != outcomePartial || len(result.Documents) != 2 {
+		t.Fatalf("Incorrect result, got %+v", result)
+	}
+	if result.Timings["convert"] < 2000 {
+		t.Errorf("Incorrect timing, got %v", result.Timings)
+	}
+	a := result.Documents[0]
+	if a.FirstPage != 2 || a.LastPage != 4 || a.DurationMS != 1500 || a.SHA256 != "abc" || a.Converter != "passthrough" {
+		t.Errorf("Incorrect document result, got %+v", a)
+	}
+	if a.Bates == nil || a.Bates.First != "X011" || a.Bates.Last != "X013" {
+		t.Errorf("Incorrect Bates range, got %+v", a.Bates)
+	}
+	if b := result.Documents[1]; b.Bates != nil || b.Status != statusFailed || b.Reason != reasonExitCode {
+		t.Errorf("Incorrect failed document result, got %+v", b)
+	}
+
+	result = newJobResult(j)
+	result.finish(j, docs[:1])
+	if result.Outcome != outcomeComplete {
+		t.Errorf("Expected a complete outcome, got %v", result.Outcome)
+	}
+}
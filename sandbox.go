@@ -0,0 +1,283 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var sandboxUser = flag.String("sandbox-user", "", "Unprivileged user external tools run as, the worker's own when empty")
+var sandboxCPU = flag.Int("sandbox-cpu", 300, "Seconds of CPU time a tool may use, 0 for no limit")
+var sandboxMemory = flag.Int("sandbox-memory", 2048, "Megabytes of memory a tool may allocate, 0 for no limit")
+var sandboxFileSize = flag.Int("sandbox-file-size", 1024, "Megabytes a tool may write to a file, 0 for no limit")
+var sandboxProcesses = flag.Int("sandbox-processes", 256, "Processes the sandbox user may run at once, 0 for no limit")
+var sandboxNamespaces = flag.Bool("sandbox-namespaces", false, "Run tools in their own network, IPC and UTS namespaces, where HTML renderers reach only the bundle server and other tools nothing")
+
+// The sandbox user's IDs, nil when tools run as the worker
+var sandboxCredential *syscall.Credential
+
+// prlimit runs each tool with its resource limits, found once
+var prlimitPath string
+
+// Looks up the sandbox user and prlimit, either missing stops the worker from starting
+func initSandbox() {
+	var err error
+	if prlimitPath, err = exec.LookPath("prlimit"); err != nil {
+		fatalLog.Fatalf("Cannot find prlimit for the sandbox: %v", err)
+	}
+	if *sandboxUser == "" {
+		return
+	}
+	u, err := user.Lookup(*sandboxUser)
+	if err != nil {
+		fatalLog.Fatalf("Cannot find the sandbox user: %v", err)
+	}
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+	if uid == 0 {
+		fatalLog.Fatalf("The sandbox user %s is root", *sandboxUser)
+	}
+	sandboxCredential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}}
+}
+
+// Confines a command before it starts: its resource limits, the sandbox user, a private temp directory for
+// TMPDIR and HOME and namespaces. An HTML renderer, with network set, keeps the network namespace it is started
+// in, which withBundle gives the bundle server and nothing else.
+// The returned function removes the temp directory once the command has finished.
+func sandbox(cmd *exec.Cmd, network bool) (func(), error) {
+	tmp, err := ioutil.TempDir("", "sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if sandboxCredential != nil {
+		if err := os.Chown(tmp, int(sandboxCredential.Uid), int(sandboxCredential.Gid)); err != nil {
+			cleanup()
+			return nil, err
+		}
+		cmd.SysProcAttr.Credential = sandboxCredential
+	}
+	if *sandboxNamespaces {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+		if !network {
+			cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+		}
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, "TMPDIR="+tmp, "TMP="+tmp, "TEMP="+tmp, "HOME="+tmp)
+	if prlimitPath != "" && cmd.Err == nil {
+		cmd.Args = append(append(append([]string{prlimitPath}, limits()...), "--", cmd.Path), cmd.Args[1:]...)
+		cmd.Path = prlimitPath
+	}
+	return cleanup, nil
+}
+
+// Runs a command in the sandbox without logging it, for commands carrying secrets
+func runQuietly(cmd *exec.Cmd) error {
+	cleanup, err := sandbox(cmd, false)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return cmd.Run()
+}
+
+// Runs fn on a thread of its own in a new network namespace with only the loopback interface, which it brings up.
+// Listeners fn opens and commands it starts are in the namespace, while goroutines run on other threads outside it.
+// The thread is never unlocked, so it exits with fn rather than going back to run other goroutines in the namespace.
+func inNetworkNamespace(fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			done <- err
+			return
+		}
+		if err := loopbackUp(); err != nil {
+			done <- err
+			return
+		}
+		done <- fn()
+	}()
+	return <-done
+}
+
+// Sets the loopback interface up with the SIOCSIFFLAGS ioctl, whose request is the interface's name and its flags
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	var request [40]byte
+	copy(request[:], "lo")
+	*(*uint16)(unsafe.Pointer(&request[syscall.IFNAMSIZ])) = syscall.IFF_UP | syscall.IFF_LOOPBACK | syscall.IFF_RUNNING
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&request[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// The prlimit options for the configured limits. A CPU limit signals SIGXCPU and kills five seconds later.
+func limits() []string {
+	args := []string{}
+	if *sandboxCPU > 0 {
+		args = append(args, fmt.Sprintf("--cpu=%d:%d", *sandboxCPU, *sandboxCPU+5))
+	}
+	if *sandboxMemory > 0 {
+		// The data segment rather than the address space, which Chromium reserves far more of than it uses
+		args = append(args, fmt.Sprintf("--data=%d", int64(*sandboxMemory)<<20))
+	}
+	if *sandboxFileSize > 0 {
+		args = append(args, fmt.Sprintf("--fsize=%d", int64(*sandboxFileSize)<<20))
+	}
+	// The process limit counts every process of the user, so it only makes sense for the sandbox user
+	if *sandboxProcesses > 0 && sandboxCredential != nil {
+		args = append(args, fmt.Sprintf("--nproc=%d", *sandboxProcesses))
+	}
+	return args
+}
+
+// A directory of its own a tool writes its outputs to, owned by the sandbox user. The job's working directories
+// stay the worker's, which takes the outputs out with collect rather than going near anything else the tool left.
+type scratchDir string
+
+func newScratch() (scratchDir, error) {
+	dir, err := ioutil.TempDir("processing", "scratch-")
+	if err != nil {
+		return "", err
+	}
+	if sandboxCredential != nil {
+		if err := os.Chown(dir, int(sandboxCredential.Uid), int(sandboxCredential.Gid)); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return scratchDir(dir), nil
+}
+
+func (s scratchDir) path(name string) string {
+	return filepath.Join(string(s), name)
+}
+
+// Opens an output, refusing links and anything else that is not a regular file
+func (s scratchDir) open(name string) (*os.File, error) {
+	f, err := os.OpenFile(s.path(name), os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil {
+		f.Close()
+		return nil, err
+	} else if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a regular file", name)
+	}
+	return f, nil
+}
+
+func (s scratchDir) read(name string) ([]byte, error) {
+	f, err := s.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// Copies an output to the file given
+func (s scratchDir) collect(name, file string) error {
+	in, err := s.open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := createFile(file)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (s scratchDir) remove() {
+	os.RemoveAll(string(s))
+}
+
+// Has write run a tool that writes the output to a scratch directory, then collects the output into file
+func fromScratch(file string, write func(output string) error) error {
+	scratch, err := newScratch()
+	if err != nil {
+		return err
+	}
+	defer scratch.remove()
+	name := filepath.Base(file)
+	if err := write(scratch.path(name)); err != nil {
+		return err
+	}
+	return scratch.collect(name, file)
+}
+
+// Creates a file for the worker to write, replacing any already there. O_EXCL and O_NOFOLLOW keep it from
+// writing through a link a tool may have left in its place.
+func createFile(name string) (*os.File, error) {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, os.FileMode(0644))
+}
+
+func writeFile(name string, data []byte) error {
+	f, err := createFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// The limit a failed tool ran into, empty when it failed for another reason. Running out of memory only
+// shows in the peak usage, as allocations fail rather than the tool being signalled.
+func resourceLimit(state *os.ProcessState) string {
+	if state == nil || state.Success() {
+		return ""
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		switch status.Signal() {
+		case syscall.SIGXCPU:
+			return "cpu time"
+		case syscall.SIGXFSZ:
+			return "file size"
+		case syscall.SIGKILL:
+			if *sandboxCPU > 0 && state.UserTime()+state.SystemTime() >= time.Duration(*sandboxCPU)*time.Second {
+				return "cpu time"
+			}
+		}
+	}
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok && *sandboxMemory > 0 && int64(usage.Maxrss)<<10 >= int64(*sandboxMemory)<<20*9/10 {
+		return "memory"
+	}
+	return ""
+}
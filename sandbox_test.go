@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	expected := []string{"--cpu=300:305", "--data=2147483648", "--fsize=1073741824"}
+	if args := limits(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+}
+
+func TestResourceLimit(t *testing.T) {
+	for script, expected := range map[string]string{
+		"kill -XCPU $$": "cpu time",
+		"kill -XFSZ $$": "file size",
+		"exit 1":        "",
+		"true":          "",
+	} {
+		cmd := exec.Command("sh", "-c", script)
+		cmd.Run()
+		if limit := resourceLimit(cmd.ProcessState); limit != expected {
+			t.Errorf("Expected %q for %s, got %q", expected, script, limit)
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	path, err := exec.LookPath("prlimit")
+	if err != nil {
+		t.Skip("prlimit is not installed")
+	}
+	defer func(p string) { prlimitPath = p }(prlimitPath)
+	prlimitPath = path
+
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", `echo "$TMPDIR"; grep "Max file size" /proc/self/limits`)
+	cmd.Stdout = &out
+	if err := run(cmd); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "sandbox-") || !strings.Contains(lines[1], "1073741824") {
+		t.Errorf("Expected a private temp directory and a file size limit, got %q", out.String())
+	}
+	if _, err := os.Stat(lines[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected the temp directory to be removed, got %v", err)
+	}
+
+	big := lines[0] + ".big"
+	defer os.Remove(big)
+	err = run(exec.Command("sh", "-c", "ulimit -f 1; exec head -c 2048 /dev/zero > "+big))
+	if te, ok := err.(*toolError); !ok || te.limit != "file size" {
+		t.Errorf("Expected the file size limit, got %#v", err)
+	}
+}
+
+func TestScratch(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Handing a scratch directory to the sandbox user needs root")
+	}
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Chmod(dir, 0755)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	os.Mkdir("processing", 0755)
+	defer func(c *syscall.Credential) { sandboxCredential = c }(sandboxCredential)
+	sandboxCredential = &syscall.Credential{Uid: 65534, Gid: 65534, Groups: []uint32{}}
+
+	err = fromScratch("processing/out.pdf", func(output string) error {
+		return run(exec.Command("sh", "-c", "echo converted > "+output+"; touch processing/planted; true"))
+	})
+	if contents, _ := ioutil.ReadFile("processing/out.pdf"); err != nil || string(contents) != "converted\n" {
+		t.Errorf("Expected the output to be collected, got %q and %v", contents, err)
+	}
+	if info, err := os.Stat("processing/out.pdf"); err != nil || info.Sys().(*syscall.Stat_t).Uid != 0 {
+		t.Errorf("Expected the worker to own the output, got %v", err)
+	}
+	if _, err := os.Stat("processing/planted"); !os.IsNotExist(err) {
+		t.Errorf("Expected the working directory to be closed to the sandbox user, got %v", err)
+	}
+
+	err = fromScratch("processing/out.pdf", func(output string) error {
+		return run(exec.Command("ln", "-s", "/etc/passwd", output))
+	})
+	if contents, _ := ioutil.ReadFile("processing/out.pdf"); err == nil || string(contents) != "converted\n" {
+		t.Errorf("Expected a link to be refused, got %q and %v", contents, err)
+	}
+	if scratches, _ := filepath.Glob("processing/scratch-*"); len(scratches) > 0 {
+		t.Errorf("Expected the scratch directories to be removed, got %v", scratches)
+	}
+}
+
+func TestInNetworkNamespace(t *testing.T) {
+	outside, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outside.Close()
+	// bash connects to the address it is given, as it opens /dev/tcp/<host>/<port>
+	reach := func(addr net.Addr) error {
+		return exec.Command("bash", "-c", "exec 3<>/dev/tcp/"+strings.Replace(addr.String(), ":", "/", 1)).Run()
+	}
+	var inside, around error
+	err = inNetworkNamespace(func() error {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		inside, around = reach(listener.Addr()), reach(outside.Addr())
+		return nil
+	})
+	if err == syscall.EPERM {
+		t.Skip("Network namespaces are not permitted")
+	} else if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if inside != nil || around == nil {
+		t.Errorf("Expected only the listener in the namespace to be reached, got %v and %v", inside, around)
+	}
+	if reach(outside.Addr()) != nil {
+		t.Errorf("Expected the worker's own network to be left as it was")
+	}
+}
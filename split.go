@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// A piece of the output uploaded on its own when the job limits the size of each file
+type part struct {
+	Key       string `json:"key"`
+	FirstPage int    `json:"first_page"`
+	LastPage  int    `json:"last_page"`
+	Size      int64  `json:"size"`
+}
+
+// Splits the output into processed/<name>-partN.pdf within the job's limits, breaking between documents where possible.
+// Returns nil when the output is already within the limits.
+func splitOutput(file, name string, j *job, marks []bookmark, pages int) ([]part, error) {
+	if pages <= 0 || (j.MaxPages <= 0 || pages <= j.MaxPages) && (j.MaxBytes <= 0 || totalSize([]string{file}) <= j.MaxBytes) {
+		return nil, nil
+	}
+	ends := sectionEnds(marks, pages)
+	parts := []part{}
+	for first := 1; first <= pages; {
+		p := part{Key: fmt.Sprintf("%s-part%d.pdf", name, len(parts)+1), FirstPage: first}
+		last := partEnd(ends, first, pages, j.MaxPages)
+		for {
+			if err := extractPages(file, first, last, "processed/"+p.Key, j.Linearize); err != nil {
+				return nil, err
+			}
+			p.Size = totalSize([]string{"processed/" + p.Key})
+			if j.MaxBytes <= 0 || p.Size <= j.MaxBytes || last == first {
+				break
+			}
+			last = shorterEnd(ends, first, last)
+		}
+		if j.MaxBytes > 0 && p.Size > j.MaxBytes {
+			infoLog.Printf("Page %d of %s alone exceeds %d bytes\n", first, file, j.MaxBytes)
+		}
+		p.LastPage = last
+		parts = append(parts, p)
+		first = last + 1
+	}
+	return parts, nil
+}
+
+// The last page of each top level section of the output, the places a part is best ended
+func sectionEnds(marks []bookmark, pages int) []int {
+	ends := []int{}
+	for _, mark := range marks {
+		end := mark.Page - 1
+		if end >= 1 && end < pages && (len(ends) == 0 || end > ends[len(ends)-1]) {
+			ends = append(ends, end)
+		}
+	}
+	return append(ends, pages)
+}
+
+// The last page of a part starting at first, ending a section when the page limit allows
+func partEnd(ends []int, first, pages, maxPages int) int {
+	if maxPages <= 0 {
+		return pages
+	}
+	limit := first + maxPages - 1
+	last := 0
+	for _, end := range ends {
+		if end >= first && end <= limit {
+			last = end
+		}
+	}
+	if last == 0 {
+		// The section is too long for a single part
+		last = limit
+		if last > pages {
+			last = pages
+		}
+	}
+	return last
+}
+
+// An earlier end for a part that came out too large, the previous section end or else half the pages
+func shorterEnd(ends []int, first, last int) int {
+	for i := len(ends) - 1; i >= 0; i-- {
+		if ends[i] >= first && ends[i] < last {
+			return ends[i]
+		}
+	}
+	return first + (last-first)/2
+}
+
+// Copies a range of pages to the output, keeping the file's encryption and metadata
+func extractPages(file string, first, last int, output string, linearized bool) error {
+	args := []string{file}
+	if linearized {
+		args = append(args, "--linearize")
+	}
+	args = append(args, "--pages", file, fmt.Sprintf("%d-%d", first, last), "--")
+	return fromScratch(output, func(part string) error {
+		return run(exec.Command("qpdf", append(args, part)...))
+	})
+}
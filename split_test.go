@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSectionEnds(t *testing.T) {
+	marks := []bookmark{{Title: "Contents", Page: 1}, {Title: "a", Page: 2}, {Title: "b", Page: 7}, {Title: "c", Page: 7}, {Title: "d", Page: 12}}
+	expected := []int{1, 6, 11, 20}
+	if ends := sectionEnds(marks, 20); !reflect.DeepEqual(ends, expected) {
+		t.Errorf("Expected %v, got %v", expected, ends)
+	}
+}
+
+func TestPartEnd(t *testing.T) {
+	ends := []int{1, 6, 11, 30}
+	for _, test := range []struct{ first, maxPages, expected int }{
+		{1, 0, 30},
+		{1, 10, 6},
+		{1, 11, 11},
+		{7, 10, 11},
+		{12, 10, 21},
+		{22, 10, 30},
+		{12, 50, 30},
+	} {
+		if last := partEnd(ends, test.first, 30, test.maxPages); last != test.expected {
+			t.Errorf("Expected a part from %d of at most %d pages to end at %d, got %d", test.first, test.maxPages, test.expected, last)
+		}
+	}
+}
+
+func TestShorterEnd(t *testing.T) {
+	ends := []int{1, 6, 11, 30}
+	for _, test := range []struct{ first, last, expected int }{
+		{1, 30, 11},
+		{1, 11, 6},
+		{2, 6, 4},
+		{12, 30, 21},
+		{12, 13, 12},
+	} {
+		if last := shorterEnd(ends, test.first, test.last); last != test.expected {
+			t.Errorf("Expected pages %d-%d to shorten to end at %d, got %d", test.first, test.last, test.expected, last)
+		}
+	}
+}
+
+func TestParseJobLimits(t *testing.T) {
+	j, err := parseJob(`{"key": "bundle", "max_pages": 50, "max_bytes": 10485760}`)
+	if err != nil || j.MaxPages != 50 || j.MaxBytes != 10485760 {
+		t.Errorf("Expected limits of 50 pages and 10 MB, got %+v %v", j, err)
+	}
+	if _, err := parseJob(`{"key": "bundle", "max_pages": -1}`); err == nil {
+		t.Error("Expected an error for a negative limit")
+	}
+}
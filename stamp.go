@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Distance kept between stamps and the edge of the page, in points
+const stampMargin = 20
+
+var fontPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+// Text stamped on every page of the output
+type stamp struct {
+	Text     string  `json:"text"`     // May use {page}, {pages}, {bates}, {bundle}, {document} and {date}
+	Position string  `json:"position"` // top-left, top-center, top-right, bottom-left, bottom-center or bottom-right, bottom-right by default
+	Font     string  `json:"font"`     // A standard PostScript font, Helvetica by default
+	Size     float64 `json:"size"`     // In points, 9 by default
+}
+
+// Sequential numbering of the output's pages, e.g. ABC000123
+type bates struct {
+	Prefix string `json:"prefix"`
+	Digits int    `json:"digits"` // Zero padding, 6 by default
+	Start  int    `json:"start"`  // Number of the first page, 1 by default
+}
+
+// Checks the numbering can be formatted, Digits of zero keep the default
+func (b *bates) validate() error {
+	if b.Digits < 0 || b.Digits > 12 {
+		return fmt.Errorf("Bates digits %d are out of range", b.Digits)
+	}
+	return nil
+}
+
+// Checks the stamp's font and position, empty ones keep their defaults
+func (s stamp) validate() error {
+	if s.Font != "" && !fontPattern.MatchString(s.Font) {
+		return fmt.Errorf("Invalid font %q", s.Font)
+	}
+	if s.Position != "" {
+		if _, _, err := stampAlignment(s.Position); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Formats the Bates number of a page of the output
+func (b *bates) number(page int) string {
+	digits, start := b.Digits, b.Start
+	if digits <= 0 {
+		digits = 6
+	}
+	if start <= 0 {
+		start = 1
+	}
+	return fmt.Sprintf("%s%0*d", b.Prefix, digits, start+page-1)
+}
+
+// The stamps requested by the job, Bates numbering alone gets a stamp of its own
+func jobStamps(j *job) []stamp {
+	stamps := append([]stamp{}, j.Stamps...)
+	if j.Bates == nil {
+		return stamps
+	}
+	for _, s := range stamps {
+		if strings.Contains(s.Text, "{bates}") {
+			return stamps
+		}
+	}
+	return append(stamps, stamp{Text: "{bates}", Position: "bottom-right"})
+}
+
+// Overlays the job's stamps onto every page of the PDF
+func stampPages(file string, j *job, marks []bookmark) error {
+	stamps := jobStamps(j)
+	for i := range stamps {
+		if stamps[i].Font == "" {
+			stamps[i].Font = "Helvetica"
+		}
+		if stamps[i].Size <= 0 {
+			stamps[i].Size = 9
+		}
+		if stamps[i].Position == "" {
+			stamps[i].Position = "bottom-right"
+		}
+		if err := stamps[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	d, err := readQpdfDocument(file)
+	if err != nil {
+		return err
+	}
+	sizes := d.pageSizes()
+	date := time.Now().Format("2006-01-02")
+	text := func(s stamp, page int) string {
+		replacements := []string{
+			"{page}", strconv.Itoa(page),
+			"{pages}", strconv.Itoa(len(sizes)),
+			"{bundle}", j.Key,
+			"{document}", pageTitle(marks, page),
+			"{date}", date,
+			"{bates}", "",
+		}
+		if j.Bates != nil {
+			replacements[len(replacements)-1] = j.Bates.number(page)
+		}
+		return strings.NewReplacer(replacements...).Replace(s.Text)
+	}
+
+	if err = writeFile("processing/stamps.ps", stampProgram(sizes, stamps, text)); err != nil {
+		return err
+	}
+	err = fromScratch("processing/stamps.pdf", func(output string) error {
+		return run(exec.Command("gs", "-dBATCH", "-dNOPAUSE", "-dQUIET", "-sDEVICE=pdfwrite", "-sOutputFile="+output, "processing/stamps.ps"))
+	})
+	if err != nil {
+		return err
+	}
+	return overlay(file, false, layer{File: "processing/stamps.pdf"})
+}
+
+// A PDF laid over or under pages of another
+type layer struct {
+	File   string
+	To     string // Pages of the destination, every page when empty
+	Repeat string // Pages of the layer repeated once the others run out
+}
+
+// Lays the layers over or under the file's content
+func overlay(file string, under bool, layers ...layer) error {
+	flag := "--overlay"
+	if under {
+		flag = "--underlay"
+	}
+	args := []string{file}
+	for _, l := range layers {
+		args = append(args, flag, l.File)
+		if l.To != "" {
+			args = append(args, "--to="+l.To)
+		}
+		if l.Repeat != "" {
+			args = append(args, "--from=", "--repeat="+l.Repeat)
+		}
+		args = append(args, "--")
+	}
+	return fromScratch(file, func(layered string) error {
+		return run(exec.Command("qpdf", append(args, layered)...))
+	})
+}
+
+// Title of the top level bookmark a page falls under
+func pageTitle(marks []bookmark, page int) string {
+	title := ""
+	for _, mark := range marks {
+		if mark.Page > page {
+			break
+		}
+		title = mark.Title
+	}
+	return title
+}
+
+// Splits a position into its horizontal and vertical alignment
+func stampAlignment(position string) (string, string, error) {
+	parts := strings.Split(position, "-")
+	if len(parts) != 2 || (parts[0] != "top" && parts[0] != "bottom") || (parts[1] != "left" && parts[1] != "center" && parts[1] != "right") {
+		return "", "", fmt.Errorf("Invalid stamp position %q", position)
+	}
+	return parts[1], parts[0], nil
+}
+
+// Writes a PostScript program drawing the stamps on pages matching the given sizes
+func stampProgram(sizes []pageSize, stamps []stamp, text func(stamp, int) string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%!PS\n")
+	// Fonts are re-encoded so text outside ASCII can be shown
+	buf.WriteString("/FrisketFont { findfont dup length dict begin { 1 index /FID ne { def } { pop pop } ifelse } forall /Encoding ISOLatin1Encoding def currentdict end definefont pop } bind def\n")
+	for i, s := range stamps {
+		fmt.Fprintf(&buf, "/FrisketFont%d /%s FrisketFont\n", i, s.Font)
+	}
+	for page, size := range sizes {
+		fmt.Fprintf(&buf, "<< /PageSize [%.2f %.2f] >> setpagedevice\n", size.Width, size.Height)
+		for i, s := range stamps {
+			horizontal, vertical, _ := stampAlignment(s.Position)
+			y := float64(stampMargin)
+			if vertical == "top" {
+				y = size.Height - stampMargin - s.Size
+			}
+			fmt.Fprintf(&buf, "/FrisketFont%d %.2f selectfont %s ", i, s.Size, psString(text(s, page+1)))
+			switch horizontal {
+			case "left":
+				fmt.Fprintf(&buf, "%d %.2f moveto show\n", stampMargin, y)
+			case "center":
+				fmt.Fprintf(&buf, "dup stringwidth pop %.2f exch sub 2 div %.2f moveto show\n", size.Width, y)
+			case "right":
+				fmt.Fprintf(&buf, "dup stringwidth pop %.2f exch sub %.2f moveto show\n", size.Width-stampMargin, y)
+			}
+		}
+		buf.WriteString("showpage\n")
+	}
+	return buf.Bytes()
+}
+
+// Escapes text as a PostScript string, characters outside Latin-1 are replaced
+func psString(s string) string {
+	var b strings.Builder
+	b.WriteString("(")
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteString("?")
+		}
+	}
+	b.WriteString(")")
+	return b.String()
+}
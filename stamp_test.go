@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBatesNumber(t *testing.T) {
+	b := &bates{Prefix: "ABC", Digits: 4, Start: 120}
+	if n := b.number(3); n != "ABC0122" {
+		t.Errorf("Incorrect number, got %v", n)
+	}
+	b = &bates{}
+	if n := b.number(1); n != "000001" {
+		t.Errorf("Incorrect default number, got %v", n)
+	}
+}
+
+func TestJobStamps(t *testing.T) {
+	j := &job{Bates: &bates{}}
+	if stamps := jobStamps(j); len(stamps) != 1 || stamps[0].Text != "{bates}" {
+		t.Errorf("Expected a Bates stamp, got %v", stamps)
+	}
+	j.Stamps = []stamp{{Text: "{bundle} {bates}"}}
+	if stamps := jobStamps(j); len(stamps) != 1 {
+		t.Errorf("Expected the job's stamp only, got %v", stamps)
+	}
+	j.Bates = nil
+	j.Stamps = nil
+	if stamps := jobStamps(j); len(stamps) != 0 {
+		t.Errorf("Expected no stamps, got %v", stamps)
+	}
+}
+
+func TestStampAlignment(t *testing.T) {
+	horizontal, vertical, err := stampAlignment("top-center")
+	if err != nil || horizontal != "center" || vertical != "top" {
+		t.Errorf("Incorrect alignment, got %v %v %v", horizontal, vertical, err)
+	}
+	for _, position := range []string{"middle-left", "top", "top-left-right", ""} {
+		if _, _, err := stampAlignment(position); err == nil {
+			t.Errorf("Expected an error for %q", position)
+		}
+	}
+}
+
+func TestPageTitle(t *testing.T) {
+	marks := []bookmark{{Title: "A", Page: 1}, {Title: "B", Page: 4}}
+	if title := pageTitle(marks, 3); title != "A" {
+		t.Errorf("Expected A, got %v", title)
+	}
+	if title := pageTitle(marks, 4); title != "B" {
+		t.Errorf("Expected B, got %v", title)
+	}
+}
+
+func TestPsString(t *testing.T) {
+	if s := psString(`a(b)\c`); s != `(a\(b\)\\c)` {
+		t.Errorf("Incorrect escaping, got %v", s)
+	}
+	if s := psString("é€"); s != `(\351?)` {
+		t.Errorf("Incorrect escaping, got %v", s)
+	}
+}
+
+func TestStampProgram(t *testing.T) {
+	stamps := []stamp{{Text: "Page {page}", Position: "top-left", Font: "Courier", Size: 10}}
+	text := func(s stamp, page int) string {
+		return strings.Replace(s.Text, "{page}", string(rune('0'+page)), -1)
+	}
+	program := string(stampProgram([]pageSize{{100, 200}, {300, 400}}, stamps, text))
+	for _, expected := range []string{
+		"/FrisketFont0 /Courier FrisketFont\n",
+		"<< /PageSize [100.00 200.00] >> setpagedevice\n/FrisketFont0 10.00 selectfont (Page 1) 20 170.00 moveto show\nshowpage\n",
+		"<< /PageSize [300.00 400.00] >> setpagedevice\n/FrisketFont0 10.00 selectfont (Page 2) 20 370.00 moveto show\nshowpage\n",
+	} {
+		if !strings.Contains(program, expected) {
+			t.Errorf("Expected %q in %v", expected, program)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"path/filepath"
+)
+
+// Where the summary of files that were not processed is rendered
+const summaryFile = "processed/summary.pdf"
+
+var summaryTemplatePath = flag.String("summary-template", "", "html/template for the summary of files not processed, replacing the built in report")
+
+var summaryFuncs = template.FuncMap{"size": formatSize}
+
+var summaryTemplate = template.Must(template.New("summary").Funcs(summaryFuncs).Parse(summaryHTML))
+
+type summaryData struct {
+	Bundle   string
+	Entries  []summaryEntry
+	Warnings []summaryEntry // Documents in the output that had problems
+}
+
+type summaryEntry struct {
+	Path   string
+	Title  string
+	Type   string
+	Size   int64
+	Status string
+	Reason string
+	Detail string
+	Stderr string
+
+	Warnings []string
+}
+
+// Parses the configured summary template, a broken template stops the worker from starting
+func initTemplates() {
+	if *summaryTemplatePath == "" {
+		return
+	}
+	t, err := template.New("summary").Funcs(summaryFuncs).ParseFiles(*summaryTemplatePath)
+	if err != nil {
+		fatalLog.Fatalf("Cannot parse the summary template: %v", err)
+	}
+	summaryTemplate = t.Lookup(filepath.Base(*summaryTemplatePath))
+}
+
+// Renders the summary of documents left out of the output and of warnings about the rest,
+// returning nil when every document made it in without any
+func renderSummary(j *job, docs []*document) (*section, error) {
+	data := summaryData{Bundle: j.Key}
+	for _, doc := range docs {
+		if !doc.excluded() && len(doc.Warnings) == 0 {
+			continue
+		}
+		infoLog.Printf("%s summarized\n", doc.Path)
+		entry := summaryEntry{
+			Path:     doc.Path,
+			Title:    doc.Title,
+			Type:     doc.Type,
+			Size:     doc.Size,
+			Status:   doc.Status,
+			Reason:   doc.Reason,
+			Detail:   doc.Detail,
+			Stderr:   doc.Stderr,
+			Warnings: doc.Warnings,
+		}
+		if doc.excluded() {
+			data.Entries = append(data.Entries, entry)
+		} else {
+			data.Warnings = append(data.Warnings, entry)
+		}
+	}
+	if len(data.Entries) == 0 && len(data.Warnings) == 0 {
+		return nil, nil
+	}
+	if err := renderTemplate(summaryTemplate, data, "processing/summary.html", summaryFile); err != nil {
+		return nil, err
+	}
+	return newSection(summaryFile, summaryTitle)
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+const summaryHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style type="text/css">
+body { font-family: Verdana, Arial, Helvetica, "PT Sans", sans-serif; font-size: 8pt; }
+h2 { font-size: 15pt; }
+table { border: 2px solid black; border-collapse: collapse; width: 100%; }
+th { background-color: #FFE6BF; text-align: left; }
+td, th { border: 1px solid black; padding: 2px; vertical-align: top; }
+.failed { color: red; font-weight: bold; }
+.skipped { color: #BF9960; font-weight: bold; }
+pre { margin: 0; white-space: pre-wrap; font-size: 7pt; }
+</style>
+</head>
+<body>
+{{if .Entries}}<h2>Files Not Processed</h2>
+<table>
+<tr><th>File</th><th>Type</th><th>Size</th><th>Reason</th><th>Output</th></tr>
+{{range .Entries}}<tr>
+<td>{{.Title}}{{if ne .Title .Path}}<br>{{.Path}}{{end}}</td>
+<td>{{.Type}}</td>
+<td>{{size .Size}}</td>
+<td class="{{.Status}}">{{.Reason}}{{if .Detail}}: {{.Detail}}{{end}}</td>
+<td>{{if .Stderr}}<pre>{{.Stderr}}</pre>{{end}}</td>
+</tr>
+{{end}}</table>
+{{end}}{{if .Warnings}}<h2>Warnings</h2>
+<table>
+<tr><th>File</th><th>Warning</th></tr>
+{{range .Warnings}}<tr>
+<td>{{.Title}}{{if ne .Title .Path}}<br>{{.Path}}{{end}}</td>
+<td>{{range .Warnings}}{{.}}<br>{{end}}</td>
+</tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSummaryTemplateEscapes(t *testing.T) {
+	var buf bytes.Buffer
+	data := summaryData{Bundle: "bundle", Entries: []summaryEntry{{
+		Path:   "<script>.docx",
+		Title:  "<script>.docx",
+		Type:   "application/octet-stream",
+		Size:   2048,
+		Status: statusFailed,
+		Reason: reasonExitCode,
+		Detail: "81",
+		Stderr: "Error: <b>source file could not be loaded</b>",
+	}}}
+	if err := summaryTemplate.Execute(&buf, data); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	html := buf.String()
+	if strings.Contains(html, "<script>") || strings.Contains(html, "<b>") {
+		t.Errorf("Values were not escaped, got %v", html)
+	}
+	for _, expected := range []string{"&lt;script&gt;.docx", "2.0 KB", "exit code: 81", `class="failed"`} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected %q in %v", expected, html)
+		}
+	}
+}
+
+func TestSummaryWarnings(t *testing.T) {
+	var buf bytes.Buffer
+	data := summaryData{Bundle: "bundle", Warnings: []summaryEntry{{
+		Path:     "index.html",
+		Title:    "Index",
+		Status:   statusConverted,
+		Warnings: []string{"blocked request for http://169.254.169.254/"},
+	}}}
+	if err := summaryTemplate.Execute(&buf, data); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	html := buf.String()
+	if strings.Contains(html, "Files Not Processed") {
+		t.Errorf("Expected no table of files not processed, got %v", html)
+	}
+	for _, expected := range []string{"<h2>Warnings</h2>", "Index<br>index.html", "blocked request for http://169.254.169.254/"} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected %q in %v", expected, html)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for size, expected := range map[int64]string{12: "12 bytes", 1536: "1.5 KB", 3 << 20: "3.0 MB"} {
+		if s := formatSize(size); s != expected {
+			t.Errorf("Expected %v, got %v", expected, s)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Formats the text of the documents is delivered in
+const (
+	textPlain = "plain" // <key>.txt, a header before each document and a form feed after each page
+	textJSON  = "json"  // <key>.text.json
+)
+
+type textOutput struct {
+	Bundle    string         `json:"bundle"`
+	Documents []documentText `json:"documents"`
+}
+
+type documentText struct {
+	Path      string     `json:"path"`
+	Title     string     `json:"title"`
+	FirstPage int        `json:"first_page"`
+	LastPage  int        `json:"last_page"`
+	Error     string     `json:"error,omitempty"`
+	Pages     []pageText `json:"pages"`
+}
+
+type pageText struct {
+	Page int    `json:"page"` // Page of the output
+	Text string `json:"text"`
+}
+
+// Extracts the text of each page of the converted document with Ghostscript, including any OCR layer
+func extractText(doc *document) ([]string, error) {
+	scratch, err := newScratch()
+	if err != nil {
+		return nil, err
+	}
+	defer scratch.remove()
+	cmd := exec.Command("gs", "-dBATCH", "-dNOPAUSE", "-dQUIET", "-sDEVICE=txtwrite", "-sOutputFile="+scratch.path("%d.txt"), doc.Output)
+	if err := run(cmd); err != nil {
+		return nil, err
+	}
+	pages := []string{}
+	for page := 1; ; page++ {
+		text, err := scratch.read(fmt.Sprintf("%d.txt", page))
+		if os.IsNotExist(err) {
+			return pages, nil
+		} else if err != nil {
+			return nil, err
+		}
+		pages = append(pages, strings.TrimRight(string(text), " \r\n"))
+	}
+}
+
+// Collects the text of the documents in the output, keyed to their pages in it
+func documentTexts(docs []*document) []documentText {
+	texts := []documentText{}
+	for _, doc := range docs {
+		if doc.Output == "" || doc.FirstPage == 0 {
+			continue
+		}
+		text := documentText{Path: doc.Path, Title: doc.Title, FirstPage: doc.FirstPage, LastPage: doc.FirstPage + doc.PageCount - 1, Pages: []pageText{}}
+		pages, err := extractText(doc)
+		if err != nil {
+			errLog.Printf("Could not extract the text of %s, err: %v", doc.Path, err)
+			text.Error = err.Error()
+		}
+		for i, page := range pages {
+			text.Pages = append(text.Pages, pageText{Page: doc.FirstPage + i, Text: page})
+		}
+		texts = append(texts, text)
+	}
+	return texts
+}
+
+// Writes the text in the requested format, returning the file, the key it is uploaded under and its content type
+func writeText(format, key string, texts []documentText) (string, string, string, error) {
+	if format == textJSON {
+		body, err := json.MarshalIndent(textOutput{Bundle: key, Documents: texts}, "", "  ")
+		if err != nil {
+			return "", "", "", err
+		}
+		return "processing/text.json", key + ".text.json", "application/json", writeFile("processing/text.json", body)
+	}
+	return "processing/text.txt", key + ".txt", "text/plain; charset=utf-8", writeFile("processing/text.txt", []byte(plainText(texts)))
+}
+
+func plainText(texts []documentText) string {
+	var b strings.Builder
+	for _, text := range texts {
+		fmt.Fprintf(&b, "==> %s (pages %d-%d) <==\n", text.Path, text.FirstPage, text.LastPage)
+		for _, page := range text.Pages {
+			b.WriteString(page.Text)
+			b.WriteString("\n\f")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestPlainText(t *testing.T) {
+	texts := []documentText{
+		{Path: "a.pdf", FirstPage: 2, LastPage: 3, Pages: []pageText{{2, "One"}, {3, "Two"}}},
+		{Path: "b/c.docx", FirstPage: 4, LastPage: 4, Pages: []pageText{{4, ""}}},
+	}
+	expected := "==> a.pdf (pages 2-3) <==\nOne\n\fTwo\n\f\n==> b/c.docx (pages 4-4) <==\n\n\f\n"
+	if text := plainText(texts); text != expected {
+		t.Errorf("Expected %q, got %q", expected, text)
+	}
+}
+
+func TestDocumentTextsSkipsExcluded(t *testing.T) {
+	docs := []*document{{Path: "a.pdf"}, {Path: "b.pdf", Output: "processed/1.pdf"}}
+	if texts := documentTexts(docs); len(texts) != 0 {
+		t.Errorf("Expected no text for documents outside the output, got %v", texts)
+	}
+}
+
+func TestParseJobText(t *testing.T) {
+	if j, err := parseJob(`{"key": "bundle", "text": "json"}`); err != nil || j.Text != textJSON {
+		t.Errorf("Expected json text, got %+v %v", j, err)
+	}
+	if _, err := parseJob(`{"key": "bundle", "text": "xml"}`); err == nil {
+		t.Error("Expected an error for an unknown text format")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// Where pages are rendered before upload
+const thumbnailDir = "processing/thumbnails"
+
+// Previews of the output's pages
+type thumbnails struct {
+	Pages  string `json:"pages"`  // first or all, first by default
+	DPI    int    `json:"dpi"`    // Resolution of the images, 72 by default
+	Format string `json:"format"` // png or webp, png by default
+}
+
+func (t *thumbnails) valid() bool {
+	return (t.Pages == "" || t.Pages == "first" || t.Pages == "all") &&
+		(t.Format == "" || t.Format == "png" || t.Format == "webp") &&
+		t.DPI >= 0 && t.DPI <= 600
+}
+
+// Renders the pages of the file to images in thumbnailDir, returning their paths in page order.
+// Ghostscript has no WebP device so WebP is converted from PNG with cwebp.
+func renderThumbnails(file string, t *thumbnails) ([]string, error) {
+	dpi := t.DPI
+	if dpi == 0 {
+		dpi = 72
+	}
+	if err := os.MkdirAll(thumbnailDir, os.FileMode(0755)); err != nil {
+		return nil, err
+	}
+	scratch, err := newScratch()
+	if err != nil {
+		return nil, err
+	}
+	defer scratch.remove()
+	args := []string{"-dBATCH", "-dNOPAUSE", "-dQUIET", "-sDEVICE=png16m", "-dTextAlphaBits=4", "-dGraphicsAlphaBits=4", fmt.Sprintf("-r%d", dpi)}
+	if t.Pages != "all" {
+		args = append(args, "-dFirstPage=1", "-dLastPage=1")
+	}
+	args = append(args, "-sOutputFile="+scratch.path("%d.png"), file)
+	if err := run(exec.Command("gs", args...)); err != nil {
+		return nil, err
+	}
+	pngs, err := filepath.Glob(scratch.path("*.png"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pngs, func(i, j int) bool { return naturalLess(pngs[i], pngs[j]) })
+	images := []string{}
+	for _, png := range pngs {
+		name := filepath.Base(png)
+		if t.Format == "webp" {
+			name = strings.TrimSuffix(name, ".png") + ".webp"
+			if err := run(exec.Command("cwebp", "-quiet", png, "-o", scratch.path(name))); err != nil {
+				return nil, err
+			}
+		}
+		image := filepath.Join(thumbnailDir, name)
+		if err := scratch.collect(name, image); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// Uploads the rendered pages as <key>/thumbnails/<page>.<format>, returning their keys
+func uploadThumbnails(key string, images []string, parentSp opentracing.Span) ([]string, *processingError) {
+	keys := []string{}
+	for _, image := range images {
+		ext := filepath.Ext(image)
+		name := key + "/thumbnails/" + filepath.Base(image)
+		if perr := uploadFile(image, name, "image/"+strings.TrimPrefix(ext, "."), parentSp); perr != nil {
+			return nil, perr
+		}
+		keys = append(keys, name)
+	}
+	return keys, nil
+}
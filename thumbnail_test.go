@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestThumbnailsValid(t *testing.T) {
+	for _, test := range []struct {
+		thumbnails thumbnails
+		valid      bool
+	}{
+		{thumbnails{}, true},
+		{thumbnails{Pages: "all", DPI: 150, Format: "webp"}, true},
+		{thumbnails{Pages: "last"}, false},
+		{thumbnails{Format: "gif"}, false},
+		{thumbnails{DPI: 1200}, false},
+		{thumbnails{DPI: -1}, false},
+	} {
+		if test.thumbnails.valid() != test.valid {
+			t.Errorf("Expected %+v to be valid %v", test.thumbnails, test.valid)
+		}
+	}
+}
+
+func TestParseJobThumbnails(t *testing.T) {
+	j, err := parseJob(`{"key": "bundle", "thumbnails": {"pages": "all", "format": "png"}}`)
+	if err != nil || j.Thumbnails == nil || j.Thumbnails.Pages != "all" {
+		t.Errorf("Expected thumbnails of every page, got %+v %v", j, err)
+	}
+	if _, err := parseJob(`{"key": "bundle", "thumbnails": {"format": "bmp"}}`); err == nil {
+		t.Error("Expected an error for an unknown thumbnail format")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var assetsDir = flag.String("assets", "assets", "Directory watermark images are loaded from when the bundle does not include them")
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+var watermarkTemplate = template.Must(template.New("watermark").Parse(watermarkHTML))
+
+// Text or an image laid over or under the output's pages
+type watermark struct {
+	Text      string   `json:"text"`      // e.g. DRAFT or CONFIDENTIAL
+	Image     string   `json:"image"`     // PNG in the bundle or the assets directory
+	Opacity   float64  `json:"opacity"`   // From 0 to 1, 0.3 by default
+	Rotation  *float64 `json:"rotation"`  // Degrees anticlockwise, 45 for text and 0 for images by default
+	Size      float64  `json:"size"`      // Text size in points, 72 by default
+	Color     string   `json:"color"`     // Text colour, #808080 by default
+	Under     bool     `json:"under"`     // Place beneath the page content rather than over it
+	Documents []string `json:"documents"` // Paths of the documents to watermark, every page when empty
+}
+
+type watermarkData struct {
+	Width, Height    string // Page size in millimetres
+	Text             string
+	Image            template.URL
+	Opacity          float64
+	Rotation         float64 // Degrees clockwise as CSS expects
+	Size             float64
+	Red, Green, Blue int
+}
+
+// Finds the watermark image, taking it out of the documents when it came with the bundle or is listed in the manifest
+func watermarkAsset(w *watermark, docs []*document) ([]*document, string) {
+	if w == nil || w.Image == "" {
+		return docs, ""
+	}
+	name := bundlePath(w.Image)
+	for i, doc := range docs {
+		if doc.Path != name {
+			continue
+		}
+		docs = append(docs[:i:i], docs[i+1:]...)
+		if doc.Source != "" {
+			return docs, doc.Source
+		}
+		break
+	}
+	return docs, filepath.Join(*assetsDir, name)
+}
+
+// Inlines an image as a data URI, as wkhtmltopdf is not given access to local files
+func imageURI(file string) (template.URL, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	content := http.DetectContentType(contents)
+	if !strings.HasPrefix(content, "image/") {
+		return "", fmt.Errorf("%s is not an image", content)
+	}
+	return template.URL("data:" + content + ";base64," + base64.StdEncoding.EncodeToString(contents)), nil
+}
+
+// Checks the watermark has something to show and can be drawn as asked
+func (w *watermark) validate() error {
+	if strings.TrimSpace(w.Text) == "" && w.Image == "" {
+		return fmt.Errorf("Watermark has no text or image")
+	}
+	if w.Opacity < 0 || w.Opacity > 1 {
+		return fmt.Errorf("Watermark opacity %v is out of range", w.Opacity)
+	}
+	if w.Color != "" && !colorPattern.MatchString(w.Color) {
+		return fmt.Errorf("Invalid watermark colour %q", w.Color)
+	}
+	return nil
+}
+
+// Lays the watermark over or under the pages of the output
+func watermarkPages(file string, w *watermark, image string, docs []*document) error {
+	data := watermarkData{Text: w.Text, Opacity: w.Opacity, Size: w.Size, Red: 128, Green: 128, Blue: 128}
+	if data.Opacity <= 0 {
+		data.Opacity = 0.3
+	}
+	if data.Size <= 0 {
+		data.Size = 72
+	}
+	if w.Rotation != nil {
+		data.Rotation = -*w.Rotation
+	} else if image == "" {
+		data.Rotation = -45
+	}
+	if w.Color != "" {
+		rgb, _ := strconv.ParseUint(w.Color[1:], 16, 32)
+		data.Red, data.Green, data.Blue = int(rgb>>16), int(rgb>>8&0xff), int(rgb&0xff)
+	}
+	if image != "" {
+		uri, err := imageURI(image)
+		if err != nil {
+			return fmt.Errorf("Could not load watermark image %v, err: %v", w.Image, err.Error())
+		}
+		data.Image = uri
+	}
+
+	d, err := readQpdfDocument(file)
+	if err != nil {
+		return err
+	}
+	selected := watermarkedPages(w.Documents, docs, len(d.Pages))
+
+	// A page is rendered for each size of page in the output and repeated onto every page of that size
+	groups := map[pageSize][]int{}
+	sizes := []pageSize{}
+	for i, size := range d.pageSizes() {
+		if !selected[i+1] {
+			continue
+		}
+		if _, ok := groups[size]; !ok {
+			sizes = append(sizes, size)
+		}
+		groups[size] = append(groups[size], i+1)
+	}
+	layers := []layer{}
+	for i, size := range sizes {
+		data.Width = fmt.Sprintf("%.2fmm", size.Width*25.4/72)
+		data.Height = fmt.Sprintf("%.2fmm", size.Height*25.4/72)
+		output := fmt.Sprintf("processing/watermark-%d.pdf", i)
+		html := fmt.Sprintf("processing/watermark-%d.html", i)
+		err = renderTemplate(watermarkTemplate, data, html, output, "--page-width", data.Width, "--page-height", data.Height,
+			"-T", "0", "-B", "0", "-L", "0", "-R", "0", "--no-background", "--disable-smart-shrinking")
+		if err != nil {
+			return err
+		}
+		layers = append(layers, layer{File: output, To: pageList(groups[size]), Repeat: "1"})
+	}
+	if len(layers) == 0 {
+		return nil
+	}
+	return overlay(file, w.Under, layers...)
+}
+
+// The pages of the output belonging to the named documents, or every page when none are named
+func watermarkedPages(paths []string, docs []*document, pages int) map[int]bool {
+	selected := map[int]bool{}
+	if len(paths) == 0 {
+		for page := 1; page <= pages; page++ {
+			selected[page] = true
+		}
+		return selected
+	}
+	for _, p := range paths {
+		p = bundlePath(p)
+		for _, doc := range docs {
+			if doc.Path != p || doc.FirstPage == 0 {
+				continue
+			}
+			for page := doc.FirstPage; page < doc.FirstPage+doc.PageCount; page++ {
+				selected[page] = true
+			}
+		}
+	}
+	return selected
+}
+
+// Formats page numbers as a range such as "1-3,5"
+func pageList(pages []int) string {
+	pages = append([]int{}, pages...)
+	sort.Ints(pages)
+	ranges := []string{}
+	for i := 0; i < len(pages); {
+		j := i
+		for j+1 < len(pages) && pages[j+1] == pages[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(pages[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", pages[i], pages[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
+
+const watermarkHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<style type="text/css">
+html, body { margin: 0; padding: 0; background: transparent; }
+.page { position: relative; overflow: hidden; }
+.mark {
+	position: absolute; top: 50%; left: 50%; white-space: nowrap;
+	font-family: Verdana, Arial, Helvetica, "PT Sans", sans-serif; font-weight: bold;
+}
+</style>
+</head>
+<body>
+<div class="page" style="width: {{.Width}}; height: {{.Height}};">
+<div class="mark" style="opacity: {{.Opacity}}; font-size: {{.Size}}pt; color: rgb({{.Red}}, {{.Green}}, {{.Blue}}); -webkit-transform: translate(-50%, -50%) rotate({{.Rotation}}deg); transform: translate(-50%, -50%) rotate({{.Rotation}}deg);">
+{{if .Image}}<img src="{{.Image}}">{{else}}{{.Text}}{{end}}
+</div>
+</div>
+</body>
+</html>
+`
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPageList(t *testing.T) {
+	if list := pageList([]int{5, 1, 2, 3, 7, 8}); list != "1-3,5,7-8" {
+		t.Errorf("Incorrect page list, got %v", list)
+	}
+	if list := pageList(nil); list != "" {
+		t.Errorf("Expected an empty page list, got %v", list)
+	}
+}
+
+func TestWatermarkedPages(t *testing.T) {
+	docs := []*document{
+		{Path: "a.pdf", FirstPage: 1, PageCount: 2},
+		{Path: "b.pdf", FirstPage: 3, PageCount: 2},
+		{Path: "c.pdf"},
+	}
+	if selected := watermarkedPages(nil, docs, 4); len(selected) != 4 {
+		t.Errorf("Expected every page, got %v", selected)
+	}
+	selected := watermarkedPages([]string{"./b.pdf", "c.pdf"}, docs, 4)
+	if len(selected) != 2 || !selected[3] || !selected[4] {
+		t.Errorf("Expected pages 3 and 4, got %v", selected)
+	}
+}
+
+func TestWatermarkAsset(t *testing.T) {
+	docs := []*document{{Path: "a.pdf"}, {Path: "logo.png", Source: "processing/logo.png"}}
+	remaining, image := watermarkAsset(&watermark{Image: "logo.png"}, docs)
+	if len(remaining) != 1 || remaining[0].Path != "a.pdf" || image != "processing/logo.png" {
+		t.Errorf("Expected the bundled image, got %v and %v", paths(remaining), image)
+	}
+	if len(docs) != 2 {
+		t.Errorf("Original documents should be untouched, got %v", paths(docs))
+	}
+	remaining, image = watermarkAsset(&watermark{Image: "../brand/logo.png"}, docs[:1])
+	if len(remaining) != 1 || image != filepath.Join(*assetsDir, "brand/logo.png") {
+		t.Errorf("Expected an image from the assets directory, got %v", image)
+	}
+	missing := &document{Path: "brand/logo.png"}
+	missing.exclude(reasonMissing, "listed in the manifest but not in the bundle")
+	remaining, image = watermarkAsset(&watermark{Image: "brand/logo.png"}, []*document{docs[0], missing})
+	if len(remaining) != 1 || image != filepath.Join(*assetsDir, "brand/logo.png") {
+		t.Errorf("Expected the manifest's entry to be replaced by the assets directory, got %v and %v", paths(remaining), image)
+	}
+	if _, image = watermarkAsset(&watermark{Text: "DRAFT"}, docs); image != "" {
+		t.Errorf("Expected no image, got %v", image)
+	}
+}
+
+func TestImageURI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frisket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	image := filepath.Join(dir, "logo.png")
+	ioutil.WriteFile(image, []byte("\x89PNG\r\n\x1a\n"), 0644)
+	if uri, err := imageURI(image); err != nil || uri != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("Expected a PNG data URI, got %v and %v", uri, err)
+	}
+	text := filepath.Join(dir, "logo.txt")
+	ioutil.WriteFile(text, []byte("not an image"), 0644)
+	if _, err := imageURI(text); err == nil {
+		t.Errorf("Expected an error for text")
+	}
+	if _, err := imageURI(filepath.Join(dir, "missing.png")); err == nil {
+		t.Errorf("Expected an error for a missing image")
+	}
+}
+
+func TestWatermarkTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	data := watermarkData{Width: "210.00mm", Height: "297.00mm", Text: "<DRAFT>", Opacity: 0.3, Rotation: -45, Size: 72, Red: 128, Green: 128, Blue: 128}
+	if err := watermarkTemplate.Execute(&buf, data); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	html := buf.String()
+	if strings.Contains(html, "ZgotmplZ") {
+		t.Errorf("Template rejected a value, got %v", html)
+	}
+	for _, expected := range []string{"width: 210.00mm", "rotate(-45deg)", "&lt;DRAFT&gt;"} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected %q in %v", expected, html)
+		}
+	}
+
+	buf.Reset()
+	data.Image = "file:///assets/logo.png"
+	if err := watermarkTemplate.Execute(&buf, data); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !strings.Contains(buf.String(), `<img src="file:///assets/logo.png">`) {
+		t.Errorf("Expected the image, got %v", buf.String())
+	}
+}